@@ -2,39 +2,164 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"io"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/vespian/go-exercises/xkcd/pkg/cmdline"
+	"github.com/vespian/go-exercises/xkcd/pkg/daemon"
+	"github.com/vespian/go-exercises/xkcd/pkg/export"
+	"github.com/vespian/go-exercises/xkcd/pkg/images"
 	"github.com/vespian/go-exercises/xkcd/pkg/index"
+	"github.com/vespian/go-exercises/xkcd/pkg/output"
+	"github.com/vespian/go-exercises/xkcd/pkg/server"
+	"github.com/vespian/go-exercises/xkcd/pkg/show"
+	"github.com/vespian/go-exercises/xkcd/pkg/termimg"
 	"github.com/vespian/go-exercises/xkcd/pkg/types"
+	"github.com/vespian/go-exercises/xkcd/pkg/web"
 )
 
+// newLogger prints the progress messages to `w`, and the notices to stderr.
+func newLogger(w io.Writer) types.Logger {
+	return func(level types.LogLevel, msg string) {
+		out := w
+		if level == types.LogNotice {
+			out = os.Stderr
+		}
+		fmt.Fprintln(out, msg)
+	}
+}
+
+// showStory prints the story on stdout, with its image drawn if stdout is a
+// terminal that can display it. Failing to get the image is only reported.
+func showStory(ctx context.Context, x *index.Index, s *types.Story,
+	c *cmdline.CommandlineArgs,
+) error {
+	var img image.Image
+	var err error
+
+	o := termimg.NewOptions(c.ShowParams, os.Stdout)
+	if o.Protocol != types.NoImage {
+		img, err = images.Load(ctx, x.Client, c.ImagesDir, s, c.Retries)
+		if err != nil {
+			x.Logger.Printf(types.LogNotice, "Unable to show the image: %s", err)
+		}
+	}
+
+	return show.Write(os.Stdout, s, img, o)
+}
+
 func main() {
-	if err := doMain(); err != nil {
+	err := doMain()
+	switch {
+	case err == nil:
+	case errors.Is(err, cmdline.ErrHelp), errors.Is(err, cmdline.ErrUsage):
+		os.Exit(cmdline.ExitCode(err))
+	default:
 		fmt.Fprintf(os.Stderr, "Operation failed: %s\n", err)
-		os.Exit(1)
+		os.Exit(cmdline.ExitCode(err))
 	}
 }
 
 func doMain() error {
-	var err error
+	c, err := cmdline.Parse(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	if c.Verbose {
+		fmt.Fprintf(os.Stderr, "%+v\n", c)
+	}
 
-	c := cmdline.Parse()
-	fmt.Printf("%+v\n", c)
+	// Operations writing their results to stdout report their progress on
+	// stderr, sync prints its notifications there by default.
+	progress := io.Writer(os.Stdout)
+	switch c.Op {
+	case types.List, types.Search, types.Show, types.Random, types.Characters,
+		types.History, types.Benchmark, types.Verify, types.Sync:
+		progress = os.Stderr
+	}
+	log := newLogger(progress)
+	x := &index.Index{File: c.IndexFile, Client: &web.Client{Logger: log}, Logger: log}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	switch c.Op {
 	case types.Update:
-		err = index.Update(c.XkcdURI, c.Range, c.IndexFile)
+		err = x.Update(ctx, c.XkcdURI, c.Range, c.FetchParams)
 	case types.List:
-		var d types.AllStories
-		if d, err = index.Fetch("", c.Range, c.IndexFile); err == nil {
-			fmt.Print(d)
+		var d types.Hits
+		if d, err = x.List(ctx, c.QueryString, c.MatchParams, c.Range); err == nil {
+			output.Sort(d, c.SortParams)
+			err = output.Write(os.Stdout, d, c.OutputParams)
 		}
 	case types.Search:
-		var d types.AllStories
-		if d, err = index.Fetch(c.QueryString, c.Range, c.IndexFile); err == nil {
-			fmt.Println(d)
+		var d types.Hits
+		if d, err = x.Search(ctx, c.QueryString, c.Range); err == nil {
+			output.Sort(d, c.SortParams)
+			err = output.Write(os.Stdout, d, c.OutputParams)
+		}
+	case types.Convert:
+		if c.ConvertTo.Location == "" {
+			err = fmt.Errorf("convert requires a destination index file")
+			break
+		}
+		c.ConvertTo.Mode = c.IndexFile.Mode
+		err = x.Convert(ctx, c.ConvertTo)
+	case types.Restore:
+		err = x.Restore(ctx)
+	case types.Mirror:
+		if c.VerifyImages {
+			err = x.VerifyImages(ctx, c.Range, c.ImagesDir)
+		} else {
+			err = x.Mirror(ctx, c.Range, c.ImagesDir, c.FetchParams)
+		}
+	case types.Serve:
+		err = server.Serve(ctx, c.Listen, x, c.ImagesDir)
+	case types.Sync:
+		err = daemon.Run(ctx, x, c.XkcdURI, c.Range, c.FetchParams, c.SyncParams)
+	case types.Show:
+		var s *types.Story
+		if s, err = x.Get(ctx, c.Num); err == nil {
+			err = showStory(ctx, x, s, c)
+		}
+	case types.Random:
+		var s *types.Story
+		if s, err = x.Random(ctx, c.QueryString, c.Range); err == nil {
+			err = showStory(ctx, x, s, c)
+		}
+	case types.Characters:
+		var cs []types.Character
+		if cs, err = x.Characters(ctx, c.QueryString, c.Range); err == nil {
+			err = output.WriteCharacters(os.Stdout, cs, c.OutputParams)
+		}
+	case types.History:
+		err = x.History(ctx, os.Stdout, c.Num)
+	case types.Benchmark:
+		err = x.Benchmark(ctx, os.Stdout)
+	case types.Export:
+		var d types.Hits
+		if d, err = x.Search(ctx, c.QueryString, c.Range); err == nil {
+			output.Sort(d, c.SortParams)
+			err = export.Write(d, c.ExportParams, c.ImagesDir, log)
+		}
+	case types.Import:
+		err = x.Import(ctx, c.ImportParams)
+	case types.Verify:
+		var r *index.Report
+		if r, err = x.Verify(ctx); err != nil {
+			break
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(r); err == nil && !r.Healthy() {
+			err = fmt.Errorf("index verification found %d errors", r.Errors)
 		}
 	default:
 		// Should not happen, but still, just in case:
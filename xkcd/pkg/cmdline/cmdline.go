@@ -1,10 +1,21 @@
 // Package cmdline gathers together cmdline stuff used by xkcd app.
+//
+// Every operation is a subcommand with its own flags, e.g. `xkcd list -query
+// barrel`. Flags that are not given on the command line are taken from the
+// XKCD_* environment variables, and then from the config file, see
+// config.go.
 package cmdline
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"unsafe"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/vespian/go-exercises/xkcd/pkg/types"
 )
@@ -14,10 +25,33 @@ import (
 type CommandlineArgs struct {
 	types.IndexFile
 	types.Range
+	types.FetchParams
+	types.MatchParams
+	types.SortParams
+	types.OutputParams
+	types.SyncParams
+	types.ExportParams
+	types.ImportParams
+	types.ShowParams
 
 	QueryString string
 	Op          types.OperationType
 	XkcdURI     string
+	// ConvertTo is the destination of the convert operation.
+	ConvertTo types.IndexFile
+	// VerifyImages makes the mirror operation check the mirrored images
+	// instead of downloading them.
+	VerifyImages bool
+	// Listen is the address the serve operation listens on.
+	Listen string
+	// Num is the story the history and show operations show.
+	Num int
+	// Config is the location of the config file the defaults were read from.
+	Config string
+	// Verbose makes xkcd print the arguments it runs with.
+	Verbose bool
+
+	noCache bool
 }
 
 func (c CommandlineArgs) String() string {
@@ -27,29 +61,417 @@ func (c CommandlineArgs) String() string {
 	res += fmt.Sprintf("  IndexFile: `%s`\n", c.IndexFile)
 	res += fmt.Sprintf("  Range: `%s`\n", c.Range)
 	res += fmt.Sprintf("  Query string: `%s`\n", c.QueryString)
+	res += fmt.Sprintf("  Match params: `%s`\n", c.MatchParams)
+	res += fmt.Sprintf("  Sort params: `%s`\n", c.SortParams)
+	res += fmt.Sprintf("  Output params: `%s`\n", c.OutputParams)
 	res += fmt.Sprintf("  XKCD uri: `%s`\n", c.XkcdURI)
+	res += fmt.Sprintf("  Fetch params: `%s`\n", c.FetchParams)
+	res += fmt.Sprintf("  Convert to: `%s`\n", c.ConvertTo)
+	res += fmt.Sprintf("  Verify images: `%t`\n", c.VerifyImages)
+	res += fmt.Sprintf("  Listen: `%s`\n", c.Listen)
+	res += fmt.Sprintf("  Sync params: `%s`\n", c.SyncParams)
+	res += fmt.Sprintf("  Num: `%d`\n", c.Num)
+	res += fmt.Sprintf("  Export params: `%s`\n", c.ExportParams)
+	res += fmt.Sprintf("  Import params: `%s`\n", c.ImportParams)
+	res += fmt.Sprintf("  Show params: `%s`\n", c.ShowParams)
+	res += fmt.Sprintf("  Config: `%s`\n", c.Config)
 	res += fmt.Sprintf("  Op: `%s`\n", c.Op)
 
 	return res
 }
 
-func Parse() *CommandlineArgs {
-	res := CommandlineArgs{
-		Op:        types.List,
-		IndexFile: types.IndexFile{Type: types.JSON},
+func defaults() *CommandlineArgs {
+	return &CommandlineArgs{
+		IndexFile:    types.IndexFile{Type: types.JSON, Mode: types.DefaultFileMode},
+		Range:        types.Range{Min: 1, Max: types.FullRange.Max},
+		FetchParams:  types.FetchParams{Workers: 1, Retries: 5, CheckpointEvery: 100},
+		MatchParams:  types.MatchParams{Mode: types.Substring, Threshold: 0.7},
+		SortParams:   types.SortParams{Key: types.ByRelevance},
+		OutputParams: types.OutputParams{Format: types.Human},
+		XkcdURI:      "http://xkcd.com/%d/info.0.json",
+		ConvertTo:    types.IndexFile{Type: types.Protobuf, Mode: types.DefaultFileMode},
+		Listen:       ":8080",
+		SyncParams:   types.SyncParams{Interval: time.Hour, Refresh: 10},
+		ExportParams: types.ExportParams{Format: types.HTMLExport},
+		ImportParams: types.ImportParams{Policy: types.KeepExisting},
 	}
+}
+
+// flagGroup registers flags shared by several commands, storing their values
+// in `res`. The defaults are the values `res` holds.
+type flagGroup func(fs *flag.FlagSet, res *CommandlineArgs)
 
-	flag.Var(&res.Type, "idx-type", "format of the on-disk index")
-	flag.StringVar(&res.Location, "idx-file", "xkcd-index",
+func indexFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.Var(&res.Type, "idx-type",
+		"format of the on-disk index (json|protobuf|bolt|pbstream|jsonl), detected for existing indexes")
+	fs.StringVar(&res.Location, "idx-file", "xkcd-index",
 		"location of the offline index file (without extension)")
-	flag.IntVar(&res.Min, "min", 1, "minimum xkcd index to process")
-	flag.IntVar(&res.Max, "max", 1<<(unsafe.Sizeof(res.Max)*8-1)-1, "maximum xkcd index to process")
-	flag.StringVar(&res.QueryString, "query", "", "String to use for filtering comics titles")
-	flag.StringVar(&res.XkcdURI, "xkcd-uri-fmt", "http://xkcd.com/%d/info.0.json",
-		"api endpoint address")
-	flag.Var(&res.Op, "op", "operation to perform")
+	fs.Var(&res.IndexFile.Mode, "idx-mode", "permissions of the index files, in octal")
+	fs.Var(&res.IndexFile.Compression, "compress",
+		"compression of new json, protobuf and stream indexes (auto|none|gzip|zstd), "+
+			"auto picks it by the .gz or .zst extension, detected for existing indexes")
+	fs.StringVar(&res.Config, "config", "",
+		"location of the config file (default: ~/.config/xkcd/config.toml)")
+	fs.BoolVar(&res.Verbose, "verbose", false, "print the arguments xkcd runs with")
+}
+
+func rangeFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.IntVar(&res.Min, "min", res.Min, "minimum xkcd index to process")
+	fs.IntVar(&res.Max, "max", res.Max, "maximum xkcd index to process")
+}
+
+func queryFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.StringVar(&res.QueryString, "query", "",
+		"query to search the comics with (e.g. 'title:barrel OR year:>=2010'), "+
+			"or to match the titles against when listing, also taken from the arguments")
+}
+
+func matchFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.Var(&res.MatchParams.Mode, "match",
+		"how the query is matched against the titles (exact|substring|regex|fuzzy)")
+	fs.Float64Var(&res.Threshold, "threshold", res.Threshold,
+		"minimum similarity (0-1) of fuzzy matches")
+}
+
+func sortFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.Var(&res.Key, "sort", "order results by (num|date|relevance)")
+	fs.Var(&res.Order, "order",
+		"sort order (asc|desc), relevance defaults to desc, everything else to asc")
+}
+
+func outputFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.Var(&res.OutputParams.Format, "output",
+		"output format (human|table|json|jsonl|csv|template)")
+	fs.StringVar(&res.Template, "template", "",
+		"Go text/template executed for every comic with the template output format, "+
+			"e.g. '{{.Num}}: {{.Title}}'")
+}
+
+func uriFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.StringVar(&res.XkcdURI, "xkcd-uri-fmt", res.XkcdURI, "api endpoint address")
+}
+
+func fetchFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.IntVar(&res.Workers, "workers", res.Workers,
+		"number of stories to fetch concurrently")
+	fs.Float64Var(&res.Rate, "rate", 0,
+		"maximum number of requests per second (0 means no limit)")
+	fs.IntVar(&res.Retries, "retries", res.Retries,
+		"number of times a failed request is retried")
+	fs.IntVar(&res.CheckpointEvery, "checkpoint", res.CheckpointEvery,
+		"store the index every N fetched stories (0 disables checkpointing)")
+}
+
+func updateFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.BoolVar(&res.WithImages, "with-images", false,
+		"mirror the images of the comics while updating")
+	fs.StringVar(&res.CacheDir, "cache-dir", "",
+		"directory of the HTTP cache of the fetched stories "+
+			"(default: index file + .cache)")
+	fs.BoolVar(&res.noCache, "no-cache", false,
+		"download all the stories instead of revalidating the cached ones")
+}
+
+func imagesFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.StringVar(&res.ImagesDir, "images-dir", "",
+		"directory of the local image store (default: index file + .images)")
+}
+
+func numFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.IntVar(&res.Num, "num", 0, "number of the story, also taken from the arguments")
+}
+
+func convertFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.Var(&res.ConvertTo.Compression, "out-compress",
+		"compression of the index created by the convert operation (auto|none|gzip|zstd)")
+	fs.Var(&res.ConvertTo.Type, "out-type",
+		"format of the index created by the convert operation (json|protobuf|bolt|pbstream|jsonl)")
+	fs.StringVar(&res.ConvertTo.Location, "out-file", "",
+		"location of the index created by the convert operation")
+}
+
+func mirrorFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.BoolVar(&res.VerifyImages, "verify", false,
+		"check the checksums of the mirrored images instead of mirroring them")
+}
+
+func serveFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.StringVar(&res.Listen, "listen", res.Listen, "address to serve the index on")
+}
+
+func syncFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.DurationVar(&res.Interval, "interval", res.Interval,
+		"time between the syncs of the sync operation")
+	fs.IntVar(&res.Refresh, "refresh", res.Refresh,
+		"number of the most recent stories fetched again on every sync to detect edits")
+	fs.Var(&res.Notify, "notify",
+		"where the sync operation sends notifications about new and edited stories "+
+			"(stdout|webhook:URL|exec:COMMAND), can be repeated (default: stdout)")
+}
+
+func exportFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.Var(&res.ExportParams.Format, "format",
+		"format of the export operation (html|epub|csv|markdown)")
+	fs.StringVar(&res.Out, "export-out", "",
+		"directory of the exported html site, or file of the other export formats "+
+			"(default: xkcd-export plus the extension of the format)")
+}
+
+func showFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.Var(&res.Protocol, "image",
+		"how the image is drawn (auto|kitty|sixel|blocks|none), auto detects it "+
+			"from the terminal")
+	fs.IntVar(&res.Width, "width", 0,
+		"maximum width of the image in columns (default: width of the terminal)")
+}
+
+func importFlags(fs *flag.FlagSet, res *CommandlineArgs) {
+	fs.Var(&res.From, "from",
+		"story file (info.0.json), directory or tar/zip archive of them, or index "+
+			"to import stories from, can be repeated")
+	fs.Var(&res.Policy, "policy",
+		"what importing does with stories already in the index "+
+			"(keep-existing|overwrite|newest-wins)")
+}
+
+// positional tells what a command does with the arguments left after the
+// flags.
+type positional int
+
+const (
+	noArgs positional = iota
+	queryArgs
+	numArg
+)
+
+// command is a subcommand of xkcd, performing one operation.
+type command struct {
+	op       types.OperationType
+	synopsis string
+	args     positional
+	flags    []flagGroup
+}
+
+func (c command) name() string {
+	return c.op.String()
+}
+
+var commands = []command{
+	{types.Update, "fetch the stories missing from the index", noArgs,
+		[]flagGroup{rangeFlags, uriFlags, fetchFlags, updateFlags, imagesFlags}},
+	{types.List, "list the stories whose titles match a query", queryArgs,
+		[]flagGroup{rangeFlags, queryFlags, matchFlags, sortFlags, outputFlags}},
+	{types.Search, "search the stories with a full-text query", queryArgs,
+		[]flagGroup{rangeFlags, queryFlags, sortFlags, outputFlags}},
+	{types.Show, "show a single comic with its image", numArg,
+		[]flagGroup{numFlags, showFlags, imagesFlags}},
+	{types.Random, "show a random comic, optionally matching a query", queryArgs,
+		[]flagGroup{rangeFlags, queryFlags, showFlags, imagesFlags}},
+	{types.Characters, "count the lines of the speakers of the stories matching a query",
+		queryArgs, []flagGroup{rangeFlags, queryFlags, outputFlags}},
+	{types.History, "show the revisions of a story", numArg,
+		[]flagGroup{numFlags}},
+	{types.Serve, "serve the index over HTTP", noArgs,
+		[]flagGroup{serveFlags, imagesFlags}},
+	{types.Sync, "keep the index in sync, notifying about new and edited stories", noArgs,
+		[]flagGroup{rangeFlags, uriFlags, fetchFlags, updateFlags, imagesFlags, syncFlags}},
+	{types.Mirror, "download the images of the stories, or verify them", noArgs,
+		[]flagGroup{rangeFlags, fetchFlags, imagesFlags, mirrorFlags}},
+	{types.Verify, "check the integrity of the index", noArgs, nil},
+	{types.Convert, "copy the index into a new one of another format", noArgs,
+		[]flagGroup{convertFlags}},
+	{types.Restore, "replace the index with its backup", noArgs, nil},
+	{types.Import, "merge story files, archives and other indexes into the index", noArgs,
+		[]flagGroup{importFlags}},
+	{types.Export, "render the stories into a site, an ebook, a table or a document", queryArgs,
+		[]flagGroup{rangeFlags, queryFlags, sortFlags, exportFlags, imagesFlags}},
+	{types.Benchmark, "compare the formats and compressions of the index", noArgs, nil},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name() == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func (c command) usage() string {
+	switch c.args {
+	case queryArgs:
+		return fmt.Sprintf("xkcd %s [flags] [query]", c.name())
+	case numArg:
+		return fmt.Sprintf("xkcd %s [flags] [num]", c.name())
+	default:
+		return fmt.Sprintf("xkcd %s [flags]", c.name())
+	}
+}
+
+// flagSet returns the flags of the command, storing their values in `res`.
+func (c command) flagSet(res *CommandlineArgs, handling flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet("xkcd "+c.name(), handling)
+
+	indexFlags(fs, res)
+	for _, g := range c.flags {
+		g(fs, res)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s.\n\nFlags:\n", c.usage(),
+			strings.ToUpper(c.synopsis[:1])+c.synopsis[1:])
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// usage prints the list of commands.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: xkcd <command> [flags] [args]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-11s%s\n", c.name(), c.synopsis)
+	}
+	fmt.Fprintf(w, "  %-11s%s\n", "completion", "print a shell completion script (bash|zsh|fish)")
+	fmt.Fprintf(w, "  %-11s%s\n", "help", "show the help of a command")
+	fmt.Fprintf(w, "\nFlags that are not given on the command line are taken from XKCD_*\n"+
+		"environment variables named after them, e.g. XKCD_IDX_FILE for -idx-file,\n"+
+		"and then from the config file, ~/.config/xkcd/config.toml by default:\n\n"+
+		"  idx-file = \"/var/lib/xkcd/index.json\"\n\n"+
+		"  [update]\n"+
+		"  workers = 4\n\n"+
+		"Keys at the top level apply to all the commands having such a flag, the\n"+
+		"ones in a table named after a command only to that command.\n")
+}
+
+// validateFetchParams rejects fetch parameters that make no sense, the flag
+// package accepts any number.
+func validateFetchParams(p types.FetchParams) error {
+	switch {
+	case p.Workers < 0:
+		return fmt.Errorf("invalid -workers %d, must not be negative", p.Workers)
+	case p.Rate < 0 || math.IsNaN(p.Rate) || math.IsInf(p.Rate, 0):
+		return fmt.Errorf("invalid -rate %g, must be a non-negative number", p.Rate)
+	case p.Retries < 0:
+		return fmt.Errorf("invalid -retries %d, must not be negative", p.Retries)
+	case p.CheckpointEvery < 0:
+		return fmt.Errorf("invalid -checkpoint %d, must not be negative", p.CheckpointEvery)
+	}
+	return nil
+}
+
+// Errors returned by Parse when there is nothing to run. The program should
+// exit with ExitCode, without printing them.
+var (
+	// ErrHelp means the help or a completion script was asked for, and was
+	// printed.
+	ErrHelp = errors.New("help requested")
+	// ErrUsage means the command line was invalid, and the usage was printed.
+	ErrUsage = errors.New("invalid usage")
+)
+
+// ExitCode returns the exit status for an error returned by Parse: 0 for
+// ErrHelp, 2 for ErrUsage and 1 for anything else.
+func ExitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrHelp):
+		return 0
+	case errors.Is(err, ErrUsage):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Parse parses the command line arguments, without the program name. Asking
+// for help or a completion script prints it and returns ErrHelp, invalid
+// flags or a missing command print the usage and return ErrUsage.
+func Parse(args []string) (*CommandlineArgs, error) {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return nil, ErrUsage
+	}
+
+	name, args := args[0], args[1:]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		if len(args) == 0 {
+			usage(os.Stdout)
+			return nil, ErrHelp
+		}
+		c, ok := findCommand(args[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown command `%s`, see `xkcd help`", args[0])
+		}
+		fs := c.flagSet(defaults(), flag.ContinueOnError)
+		fs.SetOutput(os.Stdout)
+		fs.Usage()
+		return nil, ErrHelp
+	case "completion":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("usage: xkcd completion (bash|zsh|fish)")
+		}
+		if err := writeCompletion(os.Stdout, args[0]); err != nil {
+			return nil, err
+		}
+		return nil, ErrHelp
+	}
+
+	c, ok := findCommand(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown command `%s`, see `xkcd help`", name)
+	}
+
+	res := defaults()
+	res.Op = c.op
+	fs := c.flagSet(res, flag.ContinueOnError)
+	// The flag package prints the problem and the usage itself.
+	switch err := fs.Parse(args); {
+	case err == flag.ErrHelp:
+		return nil, ErrHelp
+	case err != nil:
+		return nil, ErrUsage
+	}
+
+	if err := applyDefaults(fs, c); err != nil {
+		return nil, err
+	}
 
-	flag.Parse()
+	switch rest := fs.Args(); {
+	case len(rest) == 0:
+	case c.args == queryArgs:
+		if res.QueryString != "" {
+			return nil, fmt.Errorf("the query was given both with -query and as arguments")
+		}
+		res.QueryString = strings.Join(rest, " ")
+	case c.args == numArg && len(rest) == 1:
+		n, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid story number `%s`", rest[0])
+		}
+		res.Num = n
+	default:
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(rest, " "))
+	}
+
+	if err := validateFetchParams(res.FetchParams); err != nil {
+		return nil, err
+	}
+
+	if res.ImagesDir == "" {
+		res.ImagesDir = res.Location + ".images"
+	}
+	switch {
+	case res.noCache:
+		res.CacheDir = ""
+	case res.CacheDir == "":
+		res.CacheDir = res.Location + ".cache"
+	}
+	if res.Out == "" {
+		res.Out = res.ExportParams.Format.DefaultOut()
+	}
+	if len(res.Notify) == 0 {
+		res.Notify = types.Sinks{"stdout"}
+	}
 
-	return &res
+	return res, nil
 }
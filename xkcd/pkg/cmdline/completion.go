@@ -0,0 +1,152 @@
+package cmdline
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// completionFlag is a flag of a command, as offered by the completion scripts.
+type completionFlag struct {
+	Name string
+	// Desc is the first part of the usage of the flag.
+	Desc string
+	// Bool flags do not take a value.
+	Bool bool
+}
+
+// completionCommand is a command, as offered by the completion scripts.
+type completionCommand struct {
+	Name, Desc string
+	Flags      []completionFlag
+}
+
+// shortUsage returns the usage of the flag up to the list of its values or
+// its details.
+func shortUsage(usage string) string {
+	if i := strings.Index(usage, " ("); i > 0 {
+		usage = usage[:i]
+	}
+	return strings.TrimSpace(usage)
+}
+
+func completionCommands() []completionCommand {
+	var res []completionCommand
+
+	for _, c := range commands {
+		cc := completionCommand{Name: c.name(), Desc: c.synopsis}
+		c.flagSet(defaults(), flag.ContinueOnError).VisitAll(func(f *flag.Flag) {
+			bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+			cc.Flags = append(cc.Flags, completionFlag{
+				Name: f.Name,
+				Desc: shortUsage(f.Usage),
+				Bool: ok && bf.IsBoolFlag(),
+			})
+		})
+		res = append(res, cc)
+	}
+	res = append(res,
+		completionCommand{Name: "completion", Desc: "print a shell completion script"},
+		completionCommand{Name: "help", Desc: "show the help of a command"},
+	)
+
+	return res
+}
+
+// writeCompletion writes the completion script for the given shell, covering
+// the commands and their flags.
+func writeCompletion(w io.Writer, shell string) error {
+	switch shell {
+	case "bash", "zsh", "fish":
+		return completion.ExecuteTemplate(w, shell, completionCommands())
+	default:
+		return fmt.Errorf("unsupported shell `%s`, expected bash, zsh or fish", shell)
+	}
+}
+
+var completion = template.Must(template.New("completion").Funcs(template.FuncMap{
+	// zsh quotes the descriptions in single quotes, with the option
+	// specification characters escaped.
+	"zsh": strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace,
+	// fish quotes them in single quotes too, but escapes with backslashes.
+	"fish": strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace,
+}).Parse(`
+{{define "bash"}}# bash completion for xkcd, load with: source <(xkcd completion bash)
+_xkcd() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=($(compgen -W "{{range $i, $c := .}}{{if $i}} {{end}}{{$c.Name}}{{end}}" -- "$cur"))
+        return
+    fi
+
+    case "${COMP_WORDS[1]}" in
+{{- range .}}{{if .Flags}}
+    {{.Name}})
+        [[ "$cur" == -* ]] && COMPREPLY=($(compgen -W "{{range $i, $f := .Flags}}{{if $i}} {{end}}-{{$f.Name}}{{end}}" -- "$cur"))
+        ;;{{end}}{{end}}
+    help)
+        [ "$COMP_CWORD" -eq 2 ] && COMPREPLY=($(compgen -W "{{range $i, $c := .}}{{if $i}} {{end}}{{$c.Name}}{{end}}" -- "$cur"))
+        ;;
+    completion)
+        [ "$COMP_CWORD" -eq 2 ] && COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+        ;;
+    esac
+}
+complete -o default -F _xkcd xkcd
+{{end}}
+
+{{define "zsh"}}#compdef xkcd
+# zsh completion for xkcd, save as _xkcd in a directory of $fpath
+_xkcd() {
+    local -a commands
+    commands=(
+{{- range .}}
+        '{{.Name}}:{{zsh .Desc}}'
+{{- end}}
+    )
+
+    if (( CURRENT == 2 )); then
+        _describe 'command' commands
+        return
+    fi
+
+    local cmd="${words[2]}"
+    shift words
+    (( CURRENT-- ))
+
+    case "$cmd" in
+{{- range .}}{{if .Flags}}
+    {{.Name}})
+        _arguments \
+{{- range .Flags}}
+            '-{{.Name}}[{{zsh .Desc}}]{{if not .Bool}}:value:_files{{end}}' \
+{{- end}}
+            '*:argument:_files'
+        ;;{{end}}{{end}}
+    help)
+        _describe 'command' commands
+        ;;
+    completion)
+        _values 'shell' bash zsh fish
+        ;;
+    esac
+}
+
+_xkcd "$@"
+{{end}}
+
+{{define "fish"}}# fish completion for xkcd, load with: xkcd completion fish | source
+complete -c xkcd -f
+{{- range .}}
+complete -c xkcd -n __fish_use_subcommand -a {{.Name}} -d '{{fish .Desc}}'
+{{- end}}
+{{- range $c := .}}{{range .Flags}}
+complete -c xkcd -n '__fish_seen_subcommand_from {{$c.Name}}' -o {{.Name}} -d '{{fish .Desc}}'{{if not .Bool}} -r -F{{end}}
+{{- end}}{{end}}
+complete -c xkcd -n '__fish_seen_subcommand_from help' -a '{{range $i, $c := .}}{{if $i}} {{end}}{{$c.Name}}{{end}}'
+complete -c xkcd -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+{{end}}
+`))
@@ -0,0 +1,176 @@
+package cmdline
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// envPrefix starts the names of the environment variables setting the flags.
+const envPrefix = "XKCD_"
+
+// envName returns the environment variable setting the flag, e.g. XKCD_IDX_FILE
+// for -idx-file.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// defaultConfig returns the location of the config file used if none was
+// given, following the XDG base directory specification.
+func defaultConfig() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "xkcd", "config.toml")
+}
+
+// readConfig reads the config file. A missing file is only an error if its
+// location was given explicitly.
+func readConfig(location string, explicit bool) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+
+	blob, err := ioutil.ReadFile(location)
+	if os.IsNotExist(err) && !explicit {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = toml.Unmarshal(blob, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// configValues returns the values of a config key as flag values. Arrays set
+// repeatable flags once per element.
+func configValues(v interface{}) []string {
+	a, ok := v.([]interface{})
+	if !ok {
+		return []string{fmt.Sprint(v)}
+	}
+
+	res := make([]string, len(a))
+	for i, e := range a {
+		res[i] = fmt.Sprint(e)
+	}
+	return res
+}
+
+// knownFlags returns the names of the flags of all the commands.
+func knownFlags() map[string]bool {
+	res := map[string]bool{}
+
+	for _, c := range commands {
+		c.flagSet(defaults(), flag.ContinueOnError).VisitAll(func(f *flag.Flag) {
+			res[f.Name] = true
+		})
+	}
+
+	return res
+}
+
+// checkConfig rejects the keys of the config that are not flags of any
+// command, or of the command whose table they are in.
+func checkConfig(cfg map[string]interface{}) error {
+	var unknown []string
+
+	known := knownFlags()
+	for k, v := range cfg {
+		c, isCommand := findCommand(k)
+		if !isCommand {
+			if !known[k] || k == "config" {
+				unknown = append(unknown, k)
+			}
+			continue
+		}
+
+		table, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("`%s` has to be a table", k)
+		}
+		fs := c.flagSet(defaults(), flag.ContinueOnError)
+		for tk := range table {
+			if fs.Lookup(tk) == nil || tk == "config" {
+				unknown = append(unknown, k+"."+tk)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys: %s", strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// applyDefaults sets the flags that were not given on the command line from
+// the environment, then from the table of the command in the config file, and
+// then from the top level of the config file.
+func applyDefaults(fs *flag.FlagSet, c command) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if err != nil || set[f.Name] || !ok {
+			return
+		}
+		if serr := fs.Set(f.Name, v); serr != nil {
+			err = fmt.Errorf("invalid %s: %s", envName(f.Name), serr)
+		}
+		set[f.Name] = true
+	})
+	if err != nil {
+		return err
+	}
+
+	location := fs.Lookup("config").Value.String()
+	explicit := location != ""
+	if !explicit {
+		location = defaultConfig()
+	}
+	if location == "" {
+		return nil
+	}
+	cfg, err := readConfig(location, explicit)
+	if err == nil {
+		err = checkConfig(cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("reading config `%s` failed: %s", location, err)
+	}
+
+	table, _ := cfg[c.name()].(map[string]interface{})
+	for _, values := range []map[string]interface{}{table, cfg} {
+		for k, v := range values {
+			if _, isCommand := findCommand(k); isCommand || set[k] || fs.Lookup(k) == nil {
+				continue
+			}
+			for _, s := range configValues(v) {
+				if err = fs.Set(k, s); err != nil {
+					return fmt.Errorf("invalid `%s` in config `%s`: %s", k, location, err)
+				}
+			}
+			set[k] = true
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+// Package daemon keeps the xkcd index in sync with the site, notifying about
+// new and edited stories.
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/index"
+	"github.com/vespian/go-exercises/xkcd/pkg/notify"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// Run syncs the index right away and then every `sp.Interval`, until the
+// context is cancelled. Failed syncs are reported and retried at the next
+// interval. An interrupted sync stores the stories fetched so far before Run
+// returns. The progress is reported to the logger of the index.
+func Run(ctx context.Context, x *index.Index, url string, rg types.Range,
+	fp types.FetchParams, sp types.SyncParams,
+) error {
+	if sp.Interval <= 0 {
+		return fmt.Errorf("sync interval must be positive, got %s", sp.Interval)
+	}
+
+	sinks, err := notify.NewAll(sp.Notify)
+	if err != nil {
+		return err
+	}
+
+	x.Logger.Printf(types.LogProgress, "Syncing every %s, params: `%s`", sp.Interval, sp)
+
+	t := time.NewTicker(sp.Interval)
+	defer t.Stop()
+
+	for {
+		changes, err := x.Sync(ctx, url, rg, fp, sp.Refresh)
+		notify.Send(sinks, changes, x.Logger)
+
+		switch {
+		case ctx.Err() != nil:
+			x.Logger.Printf(types.LogProgress, "Sync interrupted, shutting down")
+			return nil
+		case err != nil:
+			x.Logger.Printf(types.LogNotice, "Sync failed: %s", err)
+		default:
+			x.Logger.Printf(types.LogProgress, "Sync done, %d changes, next one in %s",
+				len(changes), sp.Interval)
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			x.Logger.Printf(types.LogProgress, "Shutting down")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package export
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"html"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// epubImage is an image embedded in the ebook.
+type epubImage struct {
+	ID, Href, Type string
+}
+
+// epubBook holds everything needed to render the package document of the
+// ebook.
+type epubBook struct {
+	ID       string
+	Title    string
+	Modified string
+	Comics   []comic
+	Images   []epubImage
+}
+
+// epubEntry is a document of the ebook, rendered with one of the templates.
+type epubEntry struct {
+	name, tmpl string
+	data       interface{}
+}
+
+func newEPUBBook(cs []comic, paths []string) epubBook {
+	res := epubBook{
+		Modified: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Comics:   cs,
+	}
+
+	// The identifier only depends on the selected comics, so that exporting
+	// them again produces a new version of the same book.
+	h := sha256.New()
+	min, max := cs[0].Story.Num, cs[0].Story.Num
+	for _, c := range cs {
+		fmt.Fprintf(h, "%d,", c.Story.Num)
+		if c.Story.Num < min {
+			min = c.Story.Num
+		}
+		if c.Story.Num > max {
+			max = c.Story.Num
+		}
+	}
+	res.ID = "urn:xkcd:" + hex.EncodeToString(h.Sum(nil)[:16])
+	res.Title = fmt.Sprintf("xkcd %d-%d", min, max)
+	if min == max {
+		res.Title = fmt.Sprintf("xkcd %d", min)
+	}
+
+	for i, p := range paths {
+		res.Images = append(res.Images, epubImage{
+			ID:   "img" + strconv.Itoa(i),
+			Href: p,
+			Type: mime.TypeByExtension(path.Ext(p)),
+		})
+	}
+
+	return res
+}
+
+// writeEPUB writes an EPUB 3 ebook with a chapter per comic. Only the images
+// that were mirrored are embedded, the others are linked, as ebook readers
+// do not load remote images.
+func writeEPUB(w io.Writer, cs []comic, imagesDir string) error {
+	paths, src := embeddedImages(cs, imagesDir)
+	book := newEPUBBook(cs, paths)
+
+	zw := zip.NewWriter(w)
+
+	// The mimetype has to be the first entry of the archive, uncompressed and
+	// without a data descriptor, so that it can be found at a fixed offset.
+	mimetype := []byte("application/epub+zip")
+	mw, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "mimetype",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(mimetype),
+		CompressedSize64:   uint64(len(mimetype)),
+		UncompressedSize64: uint64(len(mimetype)),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = mw.Write(mimetype); err != nil {
+		return err
+	}
+
+	entries := []epubEntry{
+		{"META-INF/container.xml", "container", nil},
+		{"OEBPS/content.opf", "package", book},
+		{"OEBPS/nav.xhtml", "nav", book},
+	}
+	for _, c := range cs {
+		name := fmt.Sprintf("OEBPS/comics/%d.xhtml", c.Story.Num)
+		entries = append(entries, epubEntry{name, "comic", c})
+	}
+	for _, e := range entries {
+		ew, err := zw.Create(e.name)
+		if err != nil {
+			return err
+		}
+		if err = epub.ExecuteTemplate(ew, e.tmpl, e.data); err != nil {
+			return fmt.Errorf("rendering `%s` failed: %s", e.name, err)
+		}
+	}
+
+	for _, p := range paths {
+		if err = addFile(zw, "OEBPS/"+p, src[p]); err != nil {
+			return fmt.Errorf("embedding image `%s` failed: %s", src[p], err)
+		}
+	}
+
+	return zw.Close()
+}
+
+func addFile(zw *zip.Writer, name, location string) error {
+	f, err := os.Open(location)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Images are already compressed.
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+var epub = template.Must(template.New("epub").Funcs(template.FuncMap{
+	"x": html.EscapeString,
+}).Parse(`
+{{define "container"}}<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+{{end}}
+
+{{define "package"}}<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{x .ID}}</dc:identifier>
+    <dc:title>{{x .Title}}</dc:title>
+    <dc:creator>Randall Munroe</dc:creator>
+    <dc:language>en</dc:language>
+    <meta property="dcterms:modified">{{.Modified}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+{{- range .Comics}}
+    <item id="comic{{.Story.Num}}" href="comics/{{.Story.Num}}.xhtml" media-type="application/xhtml+xml"/>
+{{- end}}
+{{- range .Images}}
+    <item id="{{.ID}}" href="{{x .Href}}" media-type="{{x .Type}}"/>
+{{- end}}
+  </manifest>
+  <spine>
+    <itemref idref="nav"/>
+{{- range .Comics}}
+    <itemref idref="comic{{.Story.Num}}"/>
+{{- end}}
+  </spine>
+</package>
+{{end}}
+
+{{define "nav"}}<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>{{x .Title}}</title></head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>{{x .Title}}</h1>
+<ol>
+{{- range .Comics}}
+<li><a href="comics/{{.Story.Num}}.xhtml">{{.Story.Num}}: {{x .Story.Title}}</a></li>
+{{- end}}
+</ol>
+</nav>
+</body>
+</html>
+{{end}}
+
+{{define "comic"}}<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>{{x .Story.Title}}</title></head>
+<body>
+<h1>{{.Story.Num}}: {{x .Story.Title}}</h1>
+<p>{{.Date}}</p>
+{{if .Image}}<p><img src="../{{x .Image}}" alt="{{x .Story.Title}}"/></p>
+{{else if .Story.Img}}<p><a href="{{x .Story.Img}}">Image</a></p>
+{{end}}{{with .Story.Alt}}<p><em>{{x .}}</em></p>
+{{end}}{{with .Story.Transcript}}<pre>{{x .}}</pre>
+{{end}}<p><a href="{{x .URL}}">{{x .URL}}</a></p>
+</body>
+</html>
+{{end}}
+`))
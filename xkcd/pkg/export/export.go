@@ -0,0 +1,230 @@
+// Package export renders stories into documents that can be shared with
+// people who do not use xkcd: a static HTML site, an EPUB ebook, a CSV table
+// or a Markdown file. Images that were mirrored locally are embedded, the
+// others are linked.
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/images"
+	"github.com/vespian/go-exercises/xkcd/pkg/output"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// comic holds everything needed to render a single comic.
+type comic struct {
+	Story *types.Story
+	Date  string
+	// URL is the address of the comic on xkcd.com.
+	URL string
+	// Image is the path of the embedded image relative to the root of the
+	// export, empty if the image was not mirrored.
+	Image string
+	// Prev and Next are the neighbouring comics in the export, nil at its
+	// ends.
+	Prev, Next *types.Story
+}
+
+// newComics prepares the hits for rendering, in the order they were given.
+func newComics(h types.Hits, imagesDir string) []comic {
+	res := make([]comic, len(h))
+
+	for i, v := range h {
+		s := v.Story
+		res[i] = comic{
+			Story: s,
+			Date:  fmt.Sprintf("%04d-%02d-%02d", s.Year, s.Month, s.Day),
+			URL:   fmt.Sprintf("https://xkcd.com/%d/", s.Num),
+		}
+		if images.Present(imagesDir, s) {
+			res[i].Image = path.Join("images", s.LocalImage.Path)
+		}
+		if i > 0 {
+			res[i].Prev = h[i-1].Story
+		}
+		if i < len(h)-1 {
+			res[i].Next = h[i+1].Story
+		}
+	}
+
+	return res
+}
+
+// embeddedImages returns the mirrored images of the comics, keyed by their
+// paths relative to the root of the export. Identical images are only
+// returned once.
+func embeddedImages(cs []comic, imagesDir string) ([]string, map[string]string) {
+	var paths []string
+
+	src := map[string]string{}
+	for _, c := range cs {
+		if c.Image == "" {
+			continue
+		}
+		if _, ok := src[c.Image]; ok {
+			continue
+		}
+		paths = append(paths, c.Image)
+		src[c.Image] = images.Path(imagesDir, c.Story.LocalImage)
+	}
+
+	return paths, src
+}
+
+// copyImages copies the mirrored images of the comics into `dir`.
+func copyImages(cs []comic, imagesDir, dir string) error {
+	paths, src := embeddedImages(cs, imagesDir)
+
+	for _, p := range paths {
+		dst := filepath.Join(dir, filepath.FromSlash(p))
+		if err := copyFile(src[p], dst); err != nil {
+			return fmt.Errorf("copying image `%s` failed: %s", src[p], err)
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// createFile creates the file, and its directory if needed, and calls `write`
+// to fill it in.
+func createFile(location string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(location)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing `%s` failed: %s", location, err)
+	}
+
+	return nil
+}
+
+// Write exports the hits, in the order they were given. Images are embedded
+// if they were mirrored into `imagesDir`. The progress is reported to `log`.
+func Write(h types.Hits, p types.ExportParams, imagesDir string, log types.Logger) error {
+	var err error
+
+	if len(h) == 0 {
+		return fmt.Errorf("no stories were selected, nothing to export")
+	}
+	log.Printf(types.LogProgress, "Exporting %d stories, params: `%s`", len(h), p)
+
+	cs := newComics(h, imagesDir)
+	switch p.Format {
+	case types.HTMLExport:
+		err = writeSite(cs, imagesDir, p.Out)
+	case types.EPUBExport:
+		err = createFile(p.Out, func(w io.Writer) error {
+			return writeEPUB(w, cs, imagesDir)
+		})
+	case types.CSVExport:
+		err = createFile(p.Out, func(w io.Writer) error {
+			return output.Write(w, h, types.OutputParams{Format: types.CSV})
+		})
+	case types.MarkdownExport:
+		err = writeMarkdown(cs, imagesDir, p.Out)
+	default:
+		err = fmt.Errorf("unsupported export format `%s`", p.Format)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf(types.LogProgress, "Exported %d stories to `%s`", len(cs), p.Out)
+	if p.Format != types.CSVExport {
+		paths, _ := embeddedImages(cs, imagesDir)
+		log.Printf(types.LogProgress, "Embedded %d mirrored images", len(paths))
+	}
+
+	return nil
+}
+
+// markdownEscaper escapes the characters that have a special meaning in
+// Markdown text.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`,
+)
+
+// writeMarkdown writes all the comics into a single Markdown file, with the
+// embedded images copied into a directory next to it.
+func writeMarkdown(cs []comic, imagesDir, out string) error {
+	base := strings.TrimSuffix(filepath.Base(out), filepath.Ext(out)) + "_files"
+
+	err := createFile(out, func(w io.Writer) error {
+		return writeMarkdownDoc(w, cs, base)
+	})
+	if err != nil {
+		return err
+	}
+
+	return copyImages(cs, imagesDir, filepath.Join(filepath.Dir(out), base))
+}
+
+func writeMarkdownDoc(w io.Writer, cs []comic, imagesBase string) error {
+	var b strings.Builder
+
+	b.WriteString("# xkcd\n\n")
+	for _, c := range cs {
+		s := c.Story
+		title := markdownEscaper.Replace(s.Title)
+		fmt.Fprintf(&b, "- [%d: %s](#xkcd-%d)\n", s.Num, title, s.Num)
+	}
+
+	for _, c := range cs {
+		s := c.Story
+		title := markdownEscaper.Replace(s.Title)
+
+		fmt.Fprintf(&b, "\n<a id=\"xkcd-%d\"></a>\n\n", s.Num)
+		fmt.Fprintf(&b, "## %d: %s\n\n", s.Num, title)
+		fmt.Fprintf(&b, "*%s* · [xkcd.com](%s)\n\n", c.Date, c.URL)
+		switch {
+		case c.Image != "":
+			fmt.Fprintf(&b, "![%s](%s)\n\n", title, path.Join(imagesBase, c.Image))
+		case s.Img != "":
+			fmt.Fprintf(&b, "![%s](%s)\n\n", title, s.Img)
+		}
+		if s.Alt != "" {
+			fmt.Fprintf(&b, "> %s\n", markdownEscaper.Replace(s.Alt))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,74 @@
+package export
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+)
+
+// writeSite writes a static site into `dir`: an index page listing all the
+// comics, and a page per comic linking to its neighbours. Embedded images are
+// copied into the site, so that it can be browsed without xkcd or network
+// access.
+func writeSite(cs []comic, imagesDir, dir string) error {
+	err := createFile(filepath.Join(dir, "index.html"), func(w io.Writer) error {
+		return site.ExecuteTemplate(w, "index", cs)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, c := range cs {
+		location := filepath.Join(dir, "comics", fmt.Sprintf("%d.html", c.Story.Num))
+		err = createFile(location, func(w io.Writer) error {
+			return site.ExecuteTemplate(w, "comic", c)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return copyImages(cs, imagesDir, dir)
+}
+
+var site = template.Must(template.New("site").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+img { max-width: 100%; }
+nav a { margin-right: 1em; }
+td { padding: 0.2em 1em 0.2em 0; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "header" "xkcd"}}
+<h1>xkcd</h1>
+<p>{{len .}} comics</p>
+<table>
+{{range .}}<tr><td>{{.Story.Num}}</td><td>{{.Date}}</td><td><a href="comics/{{.Story.Num}}.html">{{.Story.Title}}</a></td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "comic"}}{{template "header" .Story.Title}}
+<nav><a href="../index.html">Index</a>
+{{with .Prev}}<a href="{{.Num}}.html">&lt; {{.Num}}: {{.Title}}</a>{{end}}
+{{with .Next}}<a href="{{.Num}}.html">{{.Num}}: {{.Title}} &gt;</a>{{end}}</nav>
+<h1>{{.Story.Num}}: {{.Story.Title}}</h1>
+<p>{{.Date}} · <a href="{{.URL}}">xkcd.com</a></p>
+{{if .Image}}<p><img src="../{{.Image}}" alt="{{.Story.Title}}" title="{{.Story.Alt}}"></p>
+{{else if .Story.Img}}<p><img src="{{.Story.Img}}" alt="{{.Story.Title}}" title="{{.Story.Alt}}"></p>
+{{end}}{{with .Story.Alt}}<p><em>{{.}}</em></p>
+{{end}}{{with .Story.Transcript}}<details><summary>Transcript</summary><pre>{{.}}</pre></details>
+{{end}}{{template "footer"}}{{end}}
+`))
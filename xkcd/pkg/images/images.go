@@ -0,0 +1,192 @@
+// Package images keeps local copies of the comic images in a content-addressed
+// store: every image is stored under the SHA-256 of its content, so identical
+// images are only stored once.
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	// Register the formats used by xkcd for image.Decode.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+	"github.com/vespian/go-exercises/xkcd/pkg/web"
+)
+
+// Path returns the location of the mirrored image on disk.
+func Path(dir string, img *types.Image) string {
+	return filepath.Join(dir, filepath.FromSlash(img.Path))
+}
+
+// storePath returns the path, relative to the store directory, under which a
+// blob with the given checksum is stored.
+func storePath(sum, ext string) string {
+	return path.Join(sum[:2], sum+ext)
+}
+
+func checksum(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// Present checks whether the image recorded for the story is in the store,
+// comparing only its size. Use Verify to check the content.
+func Present(dir string, s *types.Story) bool {
+	if s.LocalImage == nil {
+		return false
+	}
+
+	fi, err := os.Stat(Path(dir, s.LocalImage))
+	return err == nil && fi.Size() == s.LocalImage.Size
+}
+
+// Mirror downloads the image of the story with the client into the store,
+// unless it is already there, and records it in the story. It returns whether
+// the story was modified.
+func Mirror(ctx context.Context, c *web.Client, dir string, s *types.Story, retries int,
+) (
+	bool,
+	error,
+) {
+	if s.Img == "" || Present(dir, s) {
+		return false, nil
+	}
+
+	blob, err := c.FetchBlob(ctx, s.Img, retries)
+	if err != nil {
+		return false, err
+	}
+
+	sum, size, err := checksum(bytes.NewReader(blob))
+	if err != nil {
+		return false, err
+	}
+
+	img := &types.Image{
+		Path:   storePath(sum, strings.ToLower(path.Ext(s.Img))),
+		Size:   size,
+		SHA256: sum,
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(blob)); err == nil {
+		img.Width, img.Height = cfg.Width, cfg.Height
+	} else {
+		c.Logger.Printf(types.LogNotice,
+			"Unable to determine dimensions of image of story %d: %s", s.Num, err)
+	}
+
+	if err = write(Path(dir, img), blob); err != nil {
+		return false, fmt.Errorf("storing image of story %d failed: %s", s.Num, err)
+	}
+	s.LocalImage = img
+
+	return true, nil
+}
+
+// write stores the blob unless a file with the same name, and thus the same
+// content, is already there.
+func write(location string, blob []byte) error {
+	if _, err := os.Stat(location); err == nil {
+		return nil
+	}
+
+	dir := filepath.Dir(location)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(blob); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), location)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// Verify checks that the mirrored image of the story is present and that its
+// checksum matches the recorded one.
+func Verify(dir string, s *types.Story) error {
+	if s.LocalImage == nil {
+		return fmt.Errorf("story %d has no mirrored image", s.Num)
+	}
+
+	f, err := os.Open(Path(dir, s.LocalImage))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sum, size, err := checksum(f)
+	if err != nil {
+		return err
+	}
+	if size != s.LocalImage.Size || sum != s.LocalImage.SHA256 {
+		return fmt.Errorf("image of story %d is corrupted: expected %s (%d bytes), "+
+			"got %s (%d bytes)", s.Num, s.LocalImage.SHA256, s.LocalImage.Size, sum, size)
+	}
+
+	return nil
+}
+
+// Load returns the image of the story, from the store if it was mirrored, or
+// downloaded with the client otherwise. ErrNotFound is returned if the story
+// has no image.
+func Load(ctx context.Context, c *web.Client, dir string, s *types.Story, retries int,
+) (
+	image.Image,
+	error,
+) {
+	var blob []byte
+	var err error
+
+	switch {
+	case Present(dir, s):
+		blob, err = ioutil.ReadFile(Path(dir, s.LocalImage))
+	case s.Img != "":
+		blob, err = c.FetchBlob(ctx, s.Img, retries)
+	default:
+		return nil, fmt.Errorf("image of story %d: %w", s.Num, types.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(blob))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image of story %d failed: %s", s.Num, err)
+	}
+
+	return img, nil
+}
@@ -0,0 +1,172 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// backupLocation returns the location of the previous generation of an index
+// file.
+func backupLocation(location string) string {
+	return location + ".bak"
+}
+
+// lockLocation returns the location of the lock file guarding an index.
+func lockLocation(location string) string {
+	return location + ".lock"
+}
+
+// writeFileAtomic replaces the file with the given content so that a crash at
+// any point leaves either the old or the new version in place. The content is
+// written to a temporary file in the same directory, synced and renamed over
+// the old file, which is optionally kept as a backup.
+func writeFileAtomic(location string, blob []byte, mode os.FileMode,
+	withBackup bool,
+) error {
+	return writeAtomic(location, mode, withBackup, func(w io.Writer) error {
+		_, err := w.Write(blob)
+		return err
+	})
+}
+
+// writeAtomic works like writeFileAtomic, with the content written by `write`
+// so that it does not have to be held in memory.
+func writeAtomic(location string, mode os.FileMode, withBackup bool,
+	write func(w io.Writer) error,
+) (err error) {
+	dir := filepath.Dir(location)
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(location)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if withBackup {
+		if err = backup(location); err != nil {
+			return err
+		}
+	}
+	if err = os.Rename(tmp.Name(), location); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// backup makes the current version of the file its backup, if it exists.
+// Hard linking keeps the file in place until it is replaced.
+func backup(location string) error {
+	bak := backupLocation(location)
+
+	if err := os.Remove(bak); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err := os.Link(location, bak)
+	switch {
+	case err == nil, os.IsNotExist(err):
+		return nil
+	default:
+		// Hard links may not be supported by the filesystem.
+		return copyFile(location, bak)
+	}
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// syncDir makes a rename within the directory durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Restore replaces the index with its previous generation. The replaced
+// version becomes the backup, so restoring twice undoes the restore. Restoring
+// an index that has no backup fails with ErrNotFound. The full-text index is
+// removed, to be rebuilt by the next search.
+func (x *Index) Restore(ctx context.Context) error {
+	var blob []byte
+	var err error
+
+	idx := x.File
+	unlock, err := lockIndex(ctx, idx, x.Logger)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	bak := backupLocation(idx.Location)
+	if blob, err = ioutil.ReadFile(bak); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("backup of index `%s`: %w", idx.Location, ErrNotFound)
+		}
+		return err
+	}
+
+	x.logf(types.LogProgress, "Restoring index `%s` from `%s`", idx.Location, bak)
+
+	if err = writeFileAtomic(idx.Location, blob, idx.Mode.Perm(), true); err != nil {
+		return err
+	}
+
+	err = os.Remove(searchIndexLocation(idx))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
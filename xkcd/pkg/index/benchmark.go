@@ -0,0 +1,114 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// benchmarkRounds is the number of times every encoding is timed, the fastest
+// round is reported.
+const benchmarkRounds = 5
+
+// benchmarkResult holds the measurements of a single encoding.
+type benchmarkResult struct {
+	t      types.OndiskSerialization
+	c      types.Compression
+	size   int
+	encode time.Duration
+	decode time.Duration
+}
+
+func measure(fn func() error) (time.Duration, error) {
+	var best time.Duration
+
+	for i := 0; i < benchmarkRounds; i++ {
+		start := time.Now()
+		if err := fn(); err != nil {
+			return 0, err
+		}
+		if d := time.Since(start); i == 0 || d < best {
+			best = d
+		}
+	}
+
+	return best, nil
+}
+
+func benchmarkEncoding(h types.Header, a types.AllStories, t types.OndiskSerialization,
+	c types.Compression,
+) (
+	benchmarkResult,
+	error,
+) {
+	var blob []byte
+	var err error
+
+	res := benchmarkResult{t: t, c: c}
+
+	res.encode, err = measure(func() error {
+		blob, err = serialize(h, a, t, c)
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+	res.size = len(blob)
+
+	res.decode, err = measure(func() error {
+		_, _, err := deserialize(blob, t)
+		return err
+	})
+
+	return res, err
+}
+
+// Benchmark encodes the stories of the index in all the file formats with all
+// the compressions, and reports the size and the time it takes to encode and
+// load the index in each of them.
+func (x *Index) Benchmark(ctx context.Context, w io.Writer) (err error) {
+	var st Store
+	var a types.AllStories
+
+	if st, err = x.OpenReadOnly(); err != nil {
+		return err
+	}
+	defer closeStore(st, &err)
+
+	if a, err = readAll(st); err != nil {
+		return err
+	}
+	h := st.Header()
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "format\tcompression\tsize\tratio\tencode\tload\t\n")
+
+	var base int
+	for _, t := range []types.OndiskSerialization{
+		types.JSON, types.Protobuf, types.JSONLStream, types.PBStream,
+	} {
+		for _, c := range []types.Compression{types.NoCompression, types.Gzip, types.Zstd} {
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+			r, err := benchmarkEncoding(h, a, t, c)
+			if err != nil {
+				return fmt.Errorf("benchmarking %s with %s compression failed: %s", t, c, err)
+			}
+			if base == 0 {
+				base = r.size
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f%%\t%s\t%s\t\n", r.t, r.c, r.size,
+				100*float64(r.size)/float64(base), r.encode.Round(time.Microsecond),
+				r.decode.Round(time.Microsecond))
+		}
+	}
+	fmt.Fprintf(tw, "\n%d stories, ratio relative to uncompressed JSON, "+
+		"fastest of %d rounds\n", len(a), benchmarkRounds)
+
+	return tw.Flush()
+}
@@ -0,0 +1,438 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/vespian/go-exercises/xkcd/pkg/pbuff"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	storiesBucket = []byte("stories")
+	metaBucket    = []byte("meta")
+	headerKey     = []byte("header")
+)
+
+// boltStore keeps every story as a separate protobuf-encoded record in a bbolt
+// database, keyed by the big-endian story number so that keys sort in story
+// order. The protobuf-encoded header is kept in a separate bucket. Stories
+// that were Put are kept in memory until they are committed by Flush.
+type boltStore struct {
+	db       *bolt.DB
+	idx      types.IndexFile
+	h        types.Header
+	pending  types.AllStories
+	dirty    bool
+	readOnly bool
+	// backedUp tells whether the database was already backed up.
+	backedUp bool
+	log      types.Logger
+}
+
+// openBoltStore opens the database. Read-only stores of databases with an
+// older schema version are upgraded in memory, and so are not backed by the
+// database at all.
+func openBoltStore(idx types.IndexFile, log types.Logger, mode openMode) (Store, error) {
+	if mode == readWrite {
+		return openBoltRW(idx, log)
+	}
+
+	if _, err := os.Stat(idx.Location); os.IsNotExist(err) {
+		return memStore(types.Header{SchemaVersion: schemaVersion}, types.AllStories{}), nil
+	}
+	db, err := bolt.Open(idx.Location, idx.Mode.Perm(),
+		&bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("opening bolt index failed: %w", err)
+	}
+
+	b := &boltStore{db: db, idx: idx, readOnly: true, log: log}
+	var a types.AllStories
+	err = db.View(func(tx *bolt.Tx) error {
+		var err error
+		a, err = b.readOld(tx)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("reading bolt index failed: %w", err)
+	}
+	if a == nil || (mode == readRaw && len(a) > 0) {
+		return b, nil
+	}
+
+	db.Close()
+	if mode == readOnly {
+		if _, err = migrate(&b.h, a, idx.Location, log); err != nil {
+			return nil, err
+		}
+	}
+	return memStore(b.h, a), nil
+}
+
+func openBoltRW(idx types.IndexFile, log types.Logger) (*boltStore, error) {
+	db, err := bolt.Open(idx.Location, idx.Mode.Perm(),
+		&bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening bolt index failed: %w", err)
+	}
+
+	b := &boltStore{db: db, idx: idx, pending: types.AllStories{}, log: log}
+
+	// The upgrade of an older database is its first modification.
+	var a types.AllStories
+	err = db.View(func(tx *bolt.Tx) error {
+		a, err = b.readOld(tx)
+		return err
+	})
+	if err == nil && len(a) > 0 {
+		err = b.backup()
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("reading bolt index failed: %w", err)
+	}
+
+	if err = db.Update(b.init); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing bolt index failed: %w", err)
+	}
+
+	return b, nil
+}
+
+// readOld reads the header and, if the database has an older schema version
+// or lacks the stories bucket, all the stories, returning nil stories
+// otherwise.
+func (b *boltStore) readOld(tx *bolt.Tx) (types.AllStories, error) {
+	stories, meta := tx.Bucket(storiesBucket), tx.Bucket(metaBucket)
+
+	var v []byte
+	if meta != nil {
+		v = meta.Get(headerKey)
+	}
+	switch {
+	case v != nil:
+		tmp := new(pbuff.PBHeader)
+		if err := proto.Unmarshal(v, tmp); err != nil {
+			return nil, corrupt("pbuff unmarshaling error: %s", err)
+		}
+		b.h = pbuff.HeaderFromPBHeader(tmp)
+		if stories == nil {
+			return types.AllStories{}, nil
+		}
+		if b.h.SchemaVersion >= schemaVersion {
+			return nil, nil
+		}
+	case stories == nil:
+		// Nothing was ever stored.
+		b.h = types.Header{SchemaVersion: schemaVersion}
+		return types.AllStories{}, nil
+	case isEmpty(stories):
+		// A new database, nothing to upgrade.
+		b.h = types.Header{SchemaVersion: schemaVersion}
+		return nil, nil
+	}
+
+	a := types.AllStories{}
+	err := stories.ForEach(func(_, v []byte) error {
+		s, err := decodeBoltStory(v)
+		if err == nil {
+			a[s.Num] = s
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// init creates the buckets of a new database and reads the header of an
+// existing one, upgrading the database if it has an older schema version.
+func (b *boltStore) init(tx *bolt.Tx) error {
+	stories, err := tx.CreateBucketIfNotExists(storiesBucket)
+	if err != nil {
+		return err
+	}
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+
+	a, err := b.readOld(tx)
+	if err != nil || a == nil {
+		return err
+	}
+
+	migrated, err := migrate(&b.h, a, b.idx.Location, b.log)
+	if err != nil || !migrated {
+		return err
+	}
+	for _, s := range a {
+		if err = putBoltStory(stories, s); err != nil {
+			return err
+		}
+	}
+	return putBoltHeader(meta, &b.h)
+}
+
+func isEmpty(bucket *bolt.Bucket) bool {
+	k, _ := bucket.Cursor().First()
+	return k == nil
+}
+
+func putBoltStory(bucket *bolt.Bucket, s *types.Story) error {
+	blob, err := proto.Marshal(pbuff.PBStoryFromStory(s))
+	if err != nil {
+		return fmt.Errorf("pbuff marshaling error: %s", err)
+	}
+
+	return bucket.Put(boltKey(s.Num), blob)
+}
+
+// putBoltHeader stamps the header and stores it.
+func putBoltHeader(bucket *bolt.Bucket, h *types.Header) error {
+	stamp(h)
+
+	blob, err := proto.Marshal(pbuff.PBHeaderFromHeader(*h))
+	if err != nil {
+		return fmt.Errorf("pbuff marshaling error: %s", err)
+	}
+
+	return bucket.Put(headerKey, blob)
+}
+
+func boltKey(num int) []byte {
+	res := make([]byte, 8)
+	binary.BigEndian.PutUint64(res, uint64(num))
+	return res
+}
+
+func decodeBoltStory(v []byte) (*types.Story, error) {
+	tmp := new(pbuff.PBStory)
+
+	if err := proto.Unmarshal(v, tmp); err != nil {
+		return nil, corrupt("pbuff unmarshaling error: %s", err)
+	}
+
+	return pbuff.StoryFromPBStory(tmp), nil
+}
+
+func (b *boltStore) Get(num int) (*types.Story, error) {
+	var res *types.Story
+
+	if s, ok := b.pending[num]; ok {
+		return s, nil
+	}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(storiesBucket).Get(boltKey(num))
+		if v == nil {
+			return nil
+		}
+
+		var err error
+		res, err = decodeBoltStory(v)
+		return err
+	})
+
+	return res, err
+}
+
+// Put keeps the story in memory until the next Flush.
+func (b *boltStore) Put(s *types.Story) error {
+	if b.readOnly {
+		return errReadOnly
+	}
+	b.pending[s.Num] = s
+	b.dirty = true
+	return nil
+}
+
+// Nums lists the keys of the stories, along with the pending ones.
+func (b *boltStore) Nums() ([]int, error) {
+	res := b.pending.Nums()
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(storiesBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			num := int(binary.BigEndian.Uint64(k))
+			if _, ok := b.pending[num]; !ok {
+				res = append(res, num)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Ints(res)
+
+	return res, nil
+}
+
+func (b *boltStore) Range(rg types.Range) (types.AllStories, error) {
+	res := types.AllStories{}
+
+	err := b.iterate(rg, func(s *types.Story) error {
+		res[s.Num] = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (b *boltStore) iterate(rg types.Range, fn func(s *types.Story) error) error {
+	it, err := b.Stories(rg)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for it.Next() {
+		if err = fn(it.Story()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+func (b *boltStore) Iterate(fn func(s *types.Story) error) error {
+	return b.iterate(allStories, fn)
+}
+
+// IterateKeys only sees the stories committed to the database.
+func (b *boltStore) IterateKeys(fn func(key int, s *types.Story) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(storiesBucket).ForEach(func(k, v []byte) error {
+			s, err := decodeBoltStory(v)
+			if err != nil {
+				return err
+			}
+			return fn(int(binary.BigEndian.Uint64(k)), s)
+		})
+	})
+}
+
+func (b *boltStore) Stories(rg types.Range) (Iterator, error) {
+	it, err := b.storedStories(rg)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMergeIterator(it, newMapIterator(b.pending, rg)), nil
+}
+
+// storedStories iterates over the stories from the given range committed to
+// the database, ignoring the pending ones.
+func (b *boltStore) storedStories(rg types.Range) (Iterator, error) {
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+
+	if rg.Min < 0 {
+		rg.Min = 0
+	}
+	c := tx.Bucket(storiesBucket).Cursor()
+	k, v := c.Seek(boltKey(rg.Min))
+
+	return &funcIterator{
+		next: func() (*types.Story, error) {
+			if k == nil || int(binary.BigEndian.Uint64(k)) > rg.Max {
+				return nil, nil
+			}
+			s, err := decodeBoltStory(v)
+			k, v = c.Next()
+			return s, err
+		},
+		close: tx.Rollback,
+	}, nil
+}
+
+// backup writes a consistent copy of the database next to it, the first time
+// it is called. bbolt updates the database in place, so this has to be done
+// before modifying it.
+func (b *boltStore) backup() error {
+	if b.backedUp {
+		return nil
+	}
+	bak := backupLocation(b.idx.Location)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		blob := make([]byte, 0, tx.Size())
+		buf := bytes.NewBuffer(blob)
+		if _, err := tx.WriteTo(buf); err != nil {
+			return err
+		}
+		return writeFileAtomic(bak, buf.Bytes(), b.idx.Mode.Perm(), false)
+	})
+	if err != nil {
+		return fmt.Errorf("backing up index failed: %w", err)
+	}
+	b.backedUp = true
+
+	return nil
+}
+
+func (b *boltStore) wasBackedUp() bool { return b.backedUp }
+
+func (b *boltStore) setBackedUp() { b.backedUp = true }
+
+func (b *boltStore) Header() types.Header {
+	return b.h
+}
+
+func (b *boltStore) SetHeader(h types.Header) error {
+	if b.readOnly {
+		return errReadOnly
+	}
+	b.h = h
+	b.dirty = true
+	return b.Flush()
+}
+
+// Flush commits the pending stories and the header in a single transaction.
+func (b *boltStore) Flush() error {
+	if !b.dirty {
+		return nil
+	}
+	if err := b.backup(); err != nil {
+		return err
+	}
+
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		stories := tx.Bucket(storiesBucket)
+		for _, k := range b.pending.Nums() {
+			if err := putBoltStory(stories, b.pending[k]); err != nil {
+				return err
+			}
+		}
+		return putBoltHeader(tx.Bucket(metaBucket), &b.h)
+	})
+	if err != nil {
+		return err
+	}
+	b.pending, b.dirty = types.AllStories{}, false
+
+	return nil
+}
+
+func (b *boltStore) Close() error {
+	err := b.Flush()
+	if cerr := b.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package index
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// Characters returns the statistics of the speakers of the stories from the
+// given range matching the query, the most talkative first. Names differing
+// only in case are counted as the same character, named as in the first
+// story it speaks in.
+func (x *Index) Characters(ctx context.Context, queryString string, rg types.Range,
+) (
+	[]types.Character,
+	error,
+) {
+	h, err := x.Search(ctx, queryString, rg)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(h, func(i, j int) bool {
+		return h[i].Story.Num < h[j].Story.Num
+	})
+
+	byName := map[string]*types.Character{}
+	for _, hit := range h {
+		s := hit.Story
+		if s.Script == nil {
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, p := range s.Script.Panels {
+			for _, l := range p.Lines {
+				k := strings.ToLower(l.Speaker)
+				c, ok := byName[k]
+				if !ok {
+					c = &types.Character{Name: l.Speaker, First: s.Num}
+					byName[k] = c
+				}
+				c.Lines++
+				if !seen[k] {
+					seen[k] = true
+					c.Stories++
+					c.Last = s.Num
+				}
+			}
+		}
+	}
+
+	res := make([]types.Character, 0, len(byName))
+	for _, c := range byName {
+		res = append(res, *c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Lines != res[j].Lines {
+			return res[i].Lines > res[j].Lines
+		}
+		return res[i].Name < res[j].Name
+	})
+
+	return res, nil
+}
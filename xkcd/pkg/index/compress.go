@@ -0,0 +1,141 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// detectCompression determines the compression of the data from its first
+// bytes.
+func detectCompression(header []byte) types.Compression {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return types.Gzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return types.Zstd
+	default:
+		return types.NoCompression
+	}
+}
+
+// compressionFromExtension chooses the compression of a new index file.
+func compressionFromExtension(location string) types.Compression {
+	switch strings.ToLower(filepath.Ext(location)) {
+	case ".gz":
+		return types.Gzip
+	case ".zst", ".zstd":
+		return types.Zstd
+	default:
+		return types.NoCompression
+	}
+}
+
+// nopWriteCloser passes the data through to the underlying writer, which it
+// does not close.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newCompressor returns a writer compressing the data written to it into `w`.
+// It has to be closed to flush the compressed data, which does not close `w`.
+func newCompressor(w io.Writer, c types.Compression) (io.WriteCloser, error) {
+	switch c {
+	case types.AutoCompression, types.NoCompression:
+		return nopWriteCloser{w}, nil
+	case types.Gzip:
+		return gzip.NewWriter(w), nil
+	case types.Zstd:
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("%w: compression `%s`", ErrUnsupportedFormat, c)
+	}
+}
+
+func compress(blob []byte, c types.Compression) ([]byte, error) {
+	if c == types.AutoCompression || c == types.NoCompression {
+		return blob, nil
+	}
+
+	var buf bytes.Buffer
+
+	w, err := newCompressor(&buf, c)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(blob)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s compression failed: %s", c, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// newDecompressor returns a reader decompressing `r`, the compression being
+// detected from its magic bytes. Uncompressed data is passed through.
+func newDecompressor(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch detectCompression(header) {
+	case types.Gzip:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, corrupt("gzip decompression failed: %s", err)
+		}
+		return zr, nil
+	case types.Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, corrupt("zstd decompression failed: %s", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return ioutil.NopCloser(br), nil
+	}
+}
+
+// decompress decompresses the blob, the compression being detected from its
+// magic bytes. Uncompressed data is returned as is.
+func decompress(blob []byte) ([]byte, error) {
+	if detectCompression(blob) == types.NoCompression {
+		return blob, nil
+	}
+
+	r, err := newDecompressor(bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	res, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, corrupt("decompression failed: %s", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,162 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/pbuff"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// roundTripDiffs returns, for every story that does not survive conversion to
+// protobuf and back unchanged, the fields that got altered.
+func roundTripDiffs(a types.AllStories) map[int][]types.FieldDiff {
+	res := map[int][]types.FieldDiff{}
+
+	for k, v := range a {
+		back := pbuff.StoryFromPBStory(pbuff.PBStoryFromStory(v))
+		if d := v.Diff(back); len(d) > 0 {
+			res[k] = d
+		}
+	}
+
+	return res
+}
+
+// protobufEncoded tells whether the index type stores the stories as protobuf.
+func protobufEncoded(t types.OndiskSerialization) bool {
+	switch t {
+	case types.Protobuf, types.Bolt, types.PBStream:
+		return true
+	default:
+		return false
+	}
+}
+
+// compareStories returns the differences between two sets of stories, keyed by
+// the story number. Missing stories are reported with an empty diff.
+func compareStories(want, got types.AllStories) map[int][]types.FieldDiff {
+	res := map[int][]types.FieldDiff{}
+
+	for k, v := range want {
+		g, ok := got[k]
+		if !ok {
+			res[k] = nil
+			continue
+		}
+		if d := v.Diff(g); len(d) > 0 {
+			res[k] = d
+		}
+	}
+	for k := range got {
+		if _, ok := want[k]; !ok {
+			res[k] = nil
+		}
+	}
+
+	return res
+}
+
+func reportDiffs(log types.Logger, what string, diffs map[int][]types.FieldDiff) {
+	nums := make(types.AllStories, len(diffs))
+	for k := range diffs {
+		nums[k] = nil
+	}
+	for _, k := range nums.Nums() {
+		if len(diffs[k]) == 0 {
+			log.Printf(types.LogNotice, "%s: story %d is missing", what, k)
+			continue
+		}
+		for _, d := range diffs[k] {
+			log.Printf(types.LogNotice, "%s: story %d: %s", what, k, d)
+		}
+	}
+}
+
+// Convert copies all the stories from the index into a new `dst` index of a
+// possibly different format. Stories are checked to survive the
+// conversion to protobuf, used by the protobuf, bolt and pbstream formats,
+// without loss before anything is written, and the new index is compared with
+// the source afterwards. Any differences are reported and fail the conversion.
+// Both indexes are locked, so that the source is not replaced while it is
+// being read.
+func (x *Index) Convert(ctx context.Context, dst types.IndexFile) (err error) {
+	var in, out Store
+	var a, b types.AllStories
+
+	src := x.File
+	x.logf(types.LogProgress, "Converting index `%s` to `%s`", src, dst)
+
+	unlockSrc, err := lockIndex(ctx, src, x.Logger)
+	if err != nil {
+		return err
+	}
+	defer unlockSrc()
+	unlock, err := lockIndex(ctx, dst, x.Logger)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err = os.Stat(dst.Location); err == nil {
+		return fmt.Errorf("destination index `%s` already exists", dst.Location)
+	}
+	if _, err = os.Stat(src.Location); err != nil {
+		return err
+	}
+
+	if in, err = x.OpenReadOnly(); err != nil {
+		return err
+	}
+	defer closeStore(in, &err)
+
+	if a, err = readAll(in); err != nil {
+		return err
+	}
+
+	if protobufEncoded(dst.Type) {
+		if diffs := roundTripDiffs(a); len(diffs) > 0 {
+			reportDiffs(x.Logger, "lossy conversion", diffs)
+			return fmt.Errorf("%d stories would not survive conversion to %s",
+				len(diffs), dst.Type)
+		}
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if out, err = Open(dst, x.Logger); err != nil {
+		return err
+	}
+	if err = out.SetHeader(in.Header()); err != nil {
+		out.Close()
+		return err
+	}
+	for _, k := range a.Nums() {
+		if err = out.Put(a[k]); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	if out, err = OpenReadOnly(dst, x.Logger); err != nil {
+		return err
+	}
+	defer closeStore(out, &err)
+
+	if b, err = readAll(out); err != nil {
+		return err
+	}
+	if diffs := compareStories(a, b); len(diffs) > 0 {
+		reportDiffs(x.Logger, "verification", diffs)
+		return fmt.Errorf("%d stories differ after conversion", len(diffs))
+	}
+
+	x.logf(types.LogProgress, "Converted %d stories", len(a))
+
+	return nil
+}
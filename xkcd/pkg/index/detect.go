@@ -0,0 +1,129 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// sniffLen is the number of bytes from the start of the index file that are
+// looked at when detecting its format.
+const sniffLen = 512
+
+// boltMagic is stored in the meta page of every bbolt database, right after
+// the page header.
+const boltMagic = 0xED0CDAED
+
+// formats lists the known index formats, in the order in which they are
+// checked. Protobuf has no reliable signature, so it has to come last and
+// catches everything the others did not recognize.
+var formats = []struct {
+	t     types.OndiskSerialization
+	sniff func(header []byte) bool
+}{
+	{types.Bolt, isBolt},
+	{types.PBStream, isPBStream},
+	{types.JSONLStream, isJSONLStream},
+	{types.JSON, isJSON},
+	{types.Protobuf, func([]byte) bool { return true }},
+}
+
+func isBolt(header []byte) bool {
+	return len(header) >= 20 && binary.LittleEndian.Uint32(header[16:20]) == boltMagic
+}
+
+func isJSON(header []byte) bool {
+	trimmed := bytes.TrimLeft(header, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// detectType determines the format and the compression of an existing index
+// file from its content, looking at the format of compressed files after
+// decompressing them. It returns zeros if the file does not exist or is
+// empty.
+func detectType(location string,
+) (
+	types.OndiskSerialization,
+	types.Compression,
+	error,
+) {
+	f, err := os.Open(location)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, 0, fmt.Errorf("reading index header failed: %s", err)
+	}
+	if n == 0 {
+		return 0, 0, nil
+	}
+	c := detectCompression(magic[:n])
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	r, err := newDecompressor(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+
+	header := make([]byte, sniffLen)
+	n, err = io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, 0, fmt.Errorf("reading index header failed: %s", err)
+	}
+
+	for _, f := range formats {
+		if f.sniff(header[:n]) {
+			return f.t, c, nil
+		}
+	}
+
+	return 0, c, nil
+}
+
+// resolveType replaces the requested index type and compression with the
+// detected ones if the index file already exists. The compression of new
+// index files, unless given, is chosen by their extension.
+func resolveType(idx types.IndexFile, log types.Logger) (types.IndexFile, error) {
+	t, c, err := detectType(idx.Location)
+	if err != nil {
+		return idx, err
+	}
+
+	if t != 0 && t != idx.Type {
+		log.Printf(types.LogNotice, "Index `%s` is in %s format, ignoring requested %s",
+			idx.Location, t, idx.Type)
+		idx.Type = t
+	}
+
+	switch {
+	case t == 0 && idx.Compression == types.AutoCompression:
+		idx.Compression = compressionFromExtension(idx.Location)
+	case t != 0 && idx.Compression != types.AutoCompression && c != idx.Compression:
+		log.Printf(types.LogNotice, "Index `%s` has %s compression, ignoring requested %s",
+			idx.Location, c, idx.Compression)
+		fallthrough
+	case t != 0:
+		idx.Compression = c
+	}
+	if idx.Type == types.Bolt && idx.Compression != types.NoCompression {
+		log.Printf(types.LogNotice, "Bolt indexes can not be compressed, ignoring %s compression",
+			idx.Compression)
+		idx.Compression = types.NoCompression
+	}
+
+	return idx, nil
+}
@@ -0,0 +1,462 @@
+package index
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/search"
+	"github.com/vespian/go-exercises/xkcd/pkg/transcript"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// candidate is a story read by Import, along with where it came from.
+type candidate struct {
+	story  *types.Story
+	source string
+	// modified is when the story was obtained, compared by the newest-wins
+	// policy.
+	modified time.Time
+}
+
+// importer collects the valid stories from all the sources of an import.
+type importer struct {
+	candidates []candidate
+	rejected   int
+	log        types.Logger
+}
+
+func (im *importer) reject(source, format string, args ...interface{}) {
+	im.log.Printf(types.LogNotice, "Rejecting `%s`: %s", source,
+		fmt.Sprintf(format, args...))
+	im.rejected++
+}
+
+// add validates the story with the checks of Verify, rejecting it if any of
+// them fails with an error.
+func (im *importer) add(source string, s *types.Story, modified time.Time) {
+	r := &Report{}
+	checkStory(r, s.Num, s, time.Now())
+	if !r.Healthy() {
+		var msgs []string
+		for _, p := range r.Problems {
+			if p.Severity == SeverityError {
+				msgs = append(msgs, p.Message)
+			}
+		}
+		im.reject(source, "%s", strings.Join(msgs, ", "))
+		return
+	}
+
+	// The mirrored image belongs to the image store of wherever the story
+	// came from, it has to be mirrored again.
+	s.LocalImage = nil
+	// The script is parsed again, in case it was parsed by an older xkcd,
+	// or not at all.
+	s.Script = transcript.Parse(s.Transcript)
+	im.candidates = append(im.candidates, candidate{
+		story:    s,
+		source:   source,
+		modified: modified,
+	})
+}
+
+// addStory decodes a single story in the format of the xkcd JSON API.
+func (im *importer) addStory(source string, blob []byte, modified time.Time) {
+	blob, err := decompress(blob)
+	if err != nil {
+		im.reject(source, "%s", err)
+		return
+	}
+
+	s := new(types.Story)
+	if err = json.Unmarshal(blob, s); err != nil {
+		im.reject(source, "invalid story: %s", err)
+		return
+	}
+
+	im.add(source, s, modified)
+}
+
+// isStoryFile tells whether the file is expected to hold a single story, like
+// the info.0.json files of the xkcd JSON API, possibly compressed.
+func isStoryFile(name string) bool {
+	if compressionFromExtension(name) != types.NoCompression {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return strings.ToLower(filepath.Ext(name)) == ".json"
+}
+
+// isStoryJSON tells a single story from an index in the JSON format.
+func isStoryJSON(blob []byte) bool {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(blob, &fields); err != nil {
+		return false
+	}
+	for k := range fields {
+		if strings.EqualFold(k, "num") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isTar(header []byte) bool {
+	return len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar"))
+}
+
+func isZip(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("PK\x03\x04"))
+}
+
+// read reads the stories from a file, a directory or an archive of story
+// files, or an index.
+func (im *importer) read(location string) error {
+	fi, err := os.Stat(location)
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		return im.readDir(location)
+	}
+	return im.readFile(location, fi.ModTime())
+}
+
+func (im *importer) readDir(dir string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() || !isStoryFile(p) {
+			return nil
+		}
+
+		if err = im.readFile(p, fi.ModTime()); err != nil {
+			im.reject(p, "%s", err)
+		}
+		return nil
+	})
+}
+
+func (im *importer) readFile(location string, modified time.Time) error {
+	f, err := os.Open(location)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(zstdMagic))
+	n, _ := io.ReadFull(f, magic)
+	if isZip(magic[:n]) {
+		return im.readZip(location)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r, err := newDecompressor(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	br := bufio.NewReaderSize(r, sniffLen)
+	header, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch {
+	case isTar(header):
+		return im.readTar(location, tar.NewReader(br))
+	case isBolt(header):
+		return im.readIndex(location, types.Bolt, nil, modified)
+	}
+
+	blob, err := ioutil.ReadAll(br)
+	if err != nil {
+		return err
+	}
+	if isStoryJSON(blob) {
+		im.addStory(location, blob, modified)
+		return nil
+	}
+	for _, f := range formats {
+		if !f.sniff(blob) {
+			continue
+		}
+		// Anything can pass for protobuf, broken story files are reported
+		// as such.
+		if f.t == types.Protobuf && isStoryFile(location) {
+			im.addStory(location, blob, modified)
+			return nil
+		}
+		return im.readIndex(location, f.t, blob, modified)
+	}
+
+	return nil
+}
+
+func (im *importer) readTar(location string, tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || !isStoryFile(hdr.Name) {
+			continue
+		}
+
+		source := location + ":" + hdr.Name
+		blob, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("reading `%s` failed: %w", source, err)
+		}
+		im.addStory(source, blob, hdr.ModTime)
+	}
+}
+
+func (im *importer) readZip(location string) error {
+	zr, err := zip.OpenReader(location)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !isStoryFile(f.Name) {
+			continue
+		}
+
+		source := location + ":" + f.Name
+		r, err := f.Open()
+		if err != nil {
+			im.reject(source, "%s", err)
+			continue
+		}
+		blob, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			im.reject(source, "%s", err)
+			continue
+		}
+		im.addStory(source, blob, f.Modified)
+	}
+
+	return nil
+}
+
+// readIndex reads all the stories of another index. They are as recent as its
+// last sync, or its last update if it was never synced.
+func (im *importer) readIndex(location string, t types.OndiskSerialization, blob []byte,
+	modified time.Time,
+) error {
+	var h types.Header
+	var a types.AllStories
+	var err error
+
+	if t == types.Bolt {
+		// Opened read-only, older sources are upgraded in memory.
+		st, err := OpenReadOnly(types.IndexFile{
+			Location: location,
+			Type:     t,
+			Mode:     types.DefaultFileMode,
+		}, im.log)
+		if err != nil {
+			return err
+		}
+		a, err = readAll(st)
+		h = st.Header()
+		if cerr := st.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		if h, a, err = deserialize(blob, t); err != nil {
+			return err
+		}
+		// Only rejects indexes newer than this version of xkcd, nothing is
+		// written back.
+		if _, err = migrate(&h, a, location, im.log); err != nil {
+			return err
+		}
+	}
+
+	im.log.Printf(types.LogProgress, "Reading %d stories from %s index `%s`",
+		len(a), t, location)
+	if !h.LastSync.IsZero() {
+		modified = h.LastSync
+	} else if !h.Updated.IsZero() {
+		modified = h.Updated
+	}
+	for _, k := range a.Nums() {
+		im.add(fmt.Sprintf("%s:%d", location, k), a[k], modified)
+	}
+
+	return nil
+}
+
+// isIndexFile tells whether the location refers to the index file itself.
+func isIndexFile(location string, idx types.IndexFile) bool {
+	a, err := os.Stat(location)
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(idx.Location)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(a, b)
+}
+
+// mergeRevisions merges two revision histories of a story, ordered by the
+// time the revisions were replaced. Revisions present in both are kept once.
+func mergeRevisions(a, b []types.Revision) []types.Revision {
+	res := append([]types.Revision(nil), a...)
+	for _, r := range b {
+		dup := false
+		for _, o := range a {
+			if o.Replaced.Equal(r.Replaced) && len(o.Story.Diff(r.Story)) == 0 {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			res = append(res, r)
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Replaced.Before(res[j].Replaced)
+	})
+	return res
+}
+
+// Import reads stories from individual story files in the format of the xkcd
+// JSON API, directories of them, tar or zip archives of them, or other indexes,
+// and merges them into the index. Every story is validated with the checks of
+// Verify first, invalid ones are rejected. Stories that are already in the
+// index are resolved according to the conflict policy; with newest-wins the
+// stories of the index are as recent as its last sync, and imported ones as
+// their files, or the last sync of their index. Replaced stories are kept as
+// revisions, like on update, and the revisions of stories imported from other
+// indexes are merged with the indexed ones.
+func (x *Index) Import(ctx context.Context, params types.ImportParams) (err error) {
+	var st Store
+	var a types.AllStories
+
+	idx := x.File
+	x.logf(types.LogProgress, "Importing stories, idx: `%s`, params: `%s`", idx, params)
+
+	if len(params.From) == 0 {
+		return fmt.Errorf("import requires at least one source")
+	}
+
+	im := &importer{log: x.Logger}
+	for _, location := range params.From {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if isIndexFile(location, idx) {
+			return fmt.Errorf("`%s` is the index itself", location)
+		}
+		if err = im.read(location); err != nil {
+			return fmt.Errorf("reading `%s` failed: %w", location, err)
+		}
+	}
+	x.logf(types.LogProgress, "Read %d stories, rejected %d", len(im.candidates), im.rejected)
+
+	unlock, err := lockIndex(ctx, idx, x.Logger)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if st, err = x.Open(); err != nil {
+		return err
+	}
+	defer closeStore(st, &err)
+
+	if a, err = readAll(st); err != nil {
+		return err
+	}
+
+	h := st.Header()
+	synced := h.LastSync
+	if synced.IsZero() {
+		synced = h.Updated
+	}
+	when := map[int]time.Time{}
+	for k := range a {
+		when[k] = synced
+	}
+
+	now := time.Now()
+	added, replaced, kept, unchanged := 0, 0, 0, 0
+	for _, c := range im.candidates {
+		s := c.story
+
+		if old, ok := a[s.Num]; ok {
+			if params.Policy == types.KeepExisting ||
+				(params.Policy == types.NewestWins && !c.modified.After(when[s.Num])) {
+				kept++
+				continue
+			}
+			// The history of a story imported from another index is merged
+			// with the history of the indexed one.
+			base := *old
+			base.Revisions = mergeRevisions(old.Revisions, s.Revisions)
+			diffs := reconcile(&base, s, now)
+			if len(diffs) == 0 && len(base.Revisions) == len(old.Revisions) {
+				unchanged++
+				continue
+			}
+			x.logf(types.LogProgress, "Replacing story %d with `%s`", s.Num, c.source)
+			replaced++
+		} else {
+			added++
+		}
+
+		if err = st.Put(s); err != nil {
+			return err
+		}
+		a[s.Num], when[s.Num] = s, c.modified
+	}
+	x.logf(types.LogProgress, "Added %d stories, replaced %d, kept %d, %d were unchanged",
+		added, replaced, kept, unchanged)
+
+	if added+replaced > 0 {
+		if err = st.Flush(); err != nil {
+			return err
+		}
+		x.logf(types.LogProgress, "Rebuilding full-text index")
+		if err = storeSearchIndex(search.Build(a), idx); err != nil {
+			return err
+		}
+	}
+
+	if im.rejected > 0 {
+		return fmt.Errorf("%d stories were rejected", im.rejected)
+	}
+	return nil
+}
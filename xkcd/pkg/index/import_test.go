@@ -0,0 +1,257 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// synced is when the indexes of the tests were last synced.
+var synced = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testStory(num int, title string) *types.Story {
+	return &types.Story{Num: num, Title: title, SafeTitle: title, Year: 2010, Month: 5, Day: 1}
+}
+
+func revision(replaced time.Time, title string) types.Revision {
+	return types.Revision{Replaced: replaced, Story: testStory(1, title)}
+}
+
+// writeIndex creates a JSON index of the stories, last synced at `synced`.
+func writeIndex(t *testing.T, location string, stories ...*types.Story) types.IndexFile {
+	t.Helper()
+
+	idx := types.IndexFile{Type: types.JSON, Location: location, Mode: types.DefaultFileMode}
+	st, err := Open(idx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := st.Header()
+	h.LastSync = synced
+	if err = st.SetHeader(h); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stories {
+		if err = st.Put(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = st.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return idx
+}
+
+// writeStoryFile stores the story in the format of the xkcd JSON API, as if
+// it was obtained at `modified`.
+func writeStoryFile(t *testing.T, dir string, s *types.Story, modified time.Time) string {
+	t.Helper()
+
+	blob, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := filepath.Join(dir, fmt.Sprintf("%d.json", s.Num))
+	if err = ioutil.WriteFile(location, blob, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(location, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	return location
+}
+
+func readIndex(t *testing.T, idx types.IndexFile) types.AllStories {
+	t.Helper()
+
+	st, err := OpenReadOnly(idx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	a, err := readAll(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func titles(a types.AllStories) map[int]string {
+	res := map[int]string{}
+	for k, v := range a {
+		res[k] = v.Title
+	}
+	return res
+}
+
+func TestImportPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy types.ConflictPolicy
+		want   map[int]string
+	}{
+		{types.KeepExisting, map[int]string{1: "Old one", 2: "Old two", 3: "New three"}},
+		{types.Overwrite, map[int]string{1: "New one", 2: "New two", 3: "New three"}},
+		{types.NewestWins, map[int]string{1: "New one", 2: "Old two", 3: "New three"}},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			idx := writeIndex(t, filepath.Join(dir, "idx.json"),
+				testStory(1, "Old one"), testStory(2, "Old two"))
+
+			src := filepath.Join(dir, "src")
+			if err := os.Mkdir(src, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeStoryFile(t, src, testStory(1, "New one"), synced.Add(24*time.Hour))
+			writeStoryFile(t, src, testStory(2, "New two"), synced.Add(-24*time.Hour))
+			writeStoryFile(t, src, testStory(3, "New three"), synced.Add(-24*time.Hour))
+
+			x := &Index{File: idx}
+			err := x.Import(context.Background(), types.ImportParams{
+				From:   types.Sources{src},
+				Policy: tc.policy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a := readIndex(t, idx)
+			if got := titles(a); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			// Replaced stories are kept as revisions.
+			for k, title := range tc.want {
+				revs := a[k].Revisions
+				switch {
+				case title[:3] == "Old" || k == 3:
+					if len(revs) != 0 {
+						t.Errorf("story %d has %d revisions, want none", k, len(revs))
+					}
+				case len(revs) != 1 || revs[0].Story.Title != "Old"+title[3:]:
+					t.Errorf("story %d has revisions %v, want the old version", k, revs)
+				}
+			}
+		})
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	dir := t.TempDir()
+	idx := writeIndex(t, filepath.Join(dir, "idx.json"), testStory(1, "Old one"))
+
+	good := writeStoryFile(t, dir, testStory(2, "Two"), synced)
+	bad := writeStoryFile(t, dir, testStory(3, ""), synced)
+
+	x := &Index{File: idx}
+	err := x.Import(context.Background(), types.ImportParams{
+		From:   types.Sources{good, bad},
+		Policy: types.KeepExisting,
+	})
+	if err == nil {
+		t.Error("importing an invalid story succeeded")
+	}
+
+	want := map[int]string{1: "Old one", 2: "Two"}
+	if got := titles(readIndex(t, idx)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Importing the index into itself is refused.
+	err = x.Import(context.Background(), types.ImportParams{
+		From:   types.Sources{idx.Location},
+		Policy: types.Overwrite,
+	})
+	if err == nil {
+		t.Error("importing the index into itself succeeded")
+	}
+}
+
+func TestImportRevisions(t *testing.T) {
+	t1, t2 := synced.Add(-48*time.Hour), synced.Add(-24*time.Hour)
+
+	for _, tc := range []struct {
+		name  string
+		title string
+		want  []string
+	}{
+		// Only the history differs, it is merged without a new revision.
+		{"history only", "C", []string{"A", "B"}},
+		// The indexed story becomes the latest revision.
+		{"edited", "D", []string{"A", "B", "C"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			old := testStory(1, "C")
+			old.Revisions = []types.Revision{revision(t1, "A")}
+			idx := writeIndex(t, filepath.Join(dir, "idx.json"), old)
+
+			s := testStory(1, tc.title)
+			s.Revisions = []types.Revision{revision(t1, "A"), revision(t2, "B")}
+			src := writeIndex(t, filepath.Join(dir, "src.json"), s)
+
+			x := &Index{File: idx}
+			err := x.Import(context.Background(), types.ImportParams{
+				From:   types.Sources{src.Location},
+				Policy: types.Overwrite,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := readIndex(t, idx)[1]
+			if got.Title != tc.title {
+				t.Errorf("got title %q, want %q", got.Title, tc.title)
+			}
+			var revs []string
+			for _, r := range got.Revisions {
+				revs = append(revs, r.Story.Title)
+			}
+			if !reflect.DeepEqual(revs, tc.want) {
+				t.Errorf("got revisions %v, want %v", revs, tc.want)
+			}
+		})
+	}
+}
+
+func TestMergeRevisions(t *testing.T) {
+	t1, t2, t3 := synced, synced.Add(time.Hour), synced.Add(2*time.Hour)
+
+	for _, tc := range []struct {
+		name string
+		a, b []types.Revision
+		want []string
+	}{
+		{"empty", nil, nil, []string{}},
+		{"only indexed", []types.Revision{revision(t1, "A")}, nil, []string{"A"}},
+		{"only imported", nil, []types.Revision{revision(t1, "A")}, []string{"A"}},
+		{"same", []types.Revision{revision(t1, "A"), revision(t2, "B")},
+			[]types.Revision{revision(t1, "A"), revision(t2, "B")}, []string{"A", "B"}},
+		{"interleaved", []types.Revision{revision(t1, "A"), revision(t3, "C")},
+			[]types.Revision{revision(t2, "B")}, []string{"A", "B", "C"}},
+		{"same time, different story", []types.Revision{revision(t1, "A")},
+			[]types.Revision{revision(t1, "X")}, []string{"A", "X"}},
+	} {
+		var got []string
+		for _, r := range mergeRevisions(tc.a, tc.b) {
+			got = append(got, r.Story.Title)
+		}
+		if got == nil {
+			got = []string{}
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -1,20 +1,102 @@
+// Package index stores the stories in an index file and implements the
+// operations of the xkcd app on top of it. Programs embedding it use the
+// Index type.
 package index
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"math/rand"
 	"os"
-	"strings"
+	"sort"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/vespian/go-exercises/xkcd/pkg/match"
 	"github.com/vespian/go-exercises/xkcd/pkg/pbuff"
+	"github.com/vespian/go-exercises/xkcd/pkg/query"
+	"github.com/vespian/go-exercises/xkcd/pkg/search"
+	"github.com/vespian/go-exercises/xkcd/pkg/transcript"
 	"github.com/vespian/go-exercises/xkcd/pkg/types"
 	"github.com/vespian/go-exercises/xkcd/pkg/web"
 )
 
-func serialize(a types.AllStories, t types.OndiskSerialization,
+// Errors returned by the index package. They are the ones of package types,
+// so that they can be checked for with either.
+var (
+	ErrNotFound          = types.ErrNotFound
+	ErrCorruptIndex      = types.ErrCorruptIndex
+	ErrUnsupportedFormat = types.ErrUnsupportedFormat
+)
+
+// Index gives access to an index file. Only File has to be set: stories are
+// fetched with a zero web.Client using the Logger of the index if Client is
+// nil, and the progress messages are discarded if Logger is nil.
+//
+// Operations that take a context stop when it is cancelled, returning its
+// error. Concurrent operations modifying the same index file are serialized
+// with a lock on the file.
+type Index struct {
+	File types.IndexFile
+	// Client fetches the stories and images.
+	Client *web.Client
+	// Logger receives the progress messages of the operations.
+	Logger types.Logger
+}
+
+func (x *Index) client() *web.Client {
+	if x.Client == nil {
+		return &web.Client{Logger: x.Logger}
+	}
+	return x.Client
+}
+
+// fetchClient returns the client fetching the stories, caching them in
+// `params.CacheDir` unless the client of the index has a cache already.
+func (x *Index) fetchClient(params types.FetchParams) *web.Client {
+	c := x.client()
+	if c.Cache != nil || params.CacheDir == "" {
+		return c
+	}
+
+	res := *c
+	res.Cache = web.NewCache(params.CacheDir)
+	return &res
+}
+
+func (x *Index) logf(level types.LogLevel, format string, args ...interface{}) {
+	x.Logger.Printf(level, format, args...)
+}
+
+// Open opens the store of the index file, see Open.
+func (x *Index) Open() (Store, error) {
+	return Open(x.File, x.Logger)
+}
+
+// OpenReadOnly opens the store of the index file read-only, see OpenReadOnly.
+func (x *Index) OpenReadOnly() (Store, error) {
+	return OpenReadOnly(x.File, x.Logger)
+}
+
+// corrupt marks errors decoding an index.
+func corrupt(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrCorruptIndex, fmt.Sprintf(format, args...))
+}
+
+// jsonIndex is the layout of JSON indexes. Indexes written before the header
+// was introduced are just the story map.
+type jsonIndex struct {
+	Header  types.Header     `json:"header"`
+	Stories types.AllStories `json:"stories"`
+}
+
+// serialize encodes the index in the given format and compresses it.
+func serialize(h types.Header, a types.AllStories, t types.OndiskSerialization,
+	c types.Compression,
 ) (
 	[]byte,
 	error,
@@ -24,76 +106,244 @@ func serialize(a types.AllStories, t types.OndiskSerialization,
 
 	switch t {
 	case types.JSON:
-		blob, err = json.Marshal(a)
+		blob, err = json.Marshal(jsonIndex{Header: h, Stories: a})
 		if err != nil {
 			return nil, fmt.Errorf("Index JSON marshaling failed: %s", err)
 		}
 	case types.Protobuf:
 		pbuffDigestableStructs := pbuff.PBAllStoriesFromAllStories(a)
+		pbuffDigestableStructs.Header = pbuff.PBHeaderFromHeader(h)
 		blob, err = proto.Marshal(pbuffDigestableStructs)
 		if err != nil {
-			log.Fatal("pbuff marshaling error: ", err)
+			return nil, fmt.Errorf("pbuff marshaling error: %s", err)
 		}
+	case types.PBStream, types.JSONLStream:
+		// Stream writers compress the records themselves.
+		return serializeStream(h, a, t, c)
 	default:
-		panic("Unsupported serializing method")
+		return nil, fmt.Errorf("%w: serialization `%s`", ErrUnsupportedFormat, t)
+	}
+
+	return compress(blob, c)
+}
+
+func serializeStream(h types.Header, a types.AllStories, t types.OndiskSerialization,
+	c types.Compression,
+) (
+	[]byte,
+	error,
+) {
+	var buf bytes.Buffer
+
+	sw, err := newStreamWriter(&buf, t, c, h)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range a.Nums() {
+		if err = sw.write(a[k]); err != nil {
+			return nil, err
+		}
+	}
+	if err = sw.close(); err != nil {
+		return nil, err
 	}
 
-	return blob, err
+	return buf.Bytes(), nil
 }
 
+// deserialize decodes an index of any schema version, decompressing it first
+// if needed. Indexes without a header get a zero one, i.e. schema version 0.
 func deserialize(in []byte, t types.OndiskSerialization,
 ) (
+	types.Header,
 	types.AllStories,
 	error,
 ) {
-	var res types.AllStories
 	var err error
+	var h types.Header
+	res := types.AllStories{}
+
+	if in, err = decompress(in); err != nil {
+		return h, nil, corrupt("%s", err)
+	}
 
 	switch t {
 	case types.JSON:
-		if err = json.Unmarshal(in, &res); err != nil {
-			log.Fatalf("JSON unmarshaling failed: %s", err)
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal(in, &fields); err != nil {
+			return h, nil, corrupt("JSON unmarshaling failed: %s", err)
+		}
+		if _, ok := fields["header"]; !ok {
+			if err = json.Unmarshal(in, &res); err != nil {
+				return h, nil, corrupt("JSON unmarshaling failed: %s", err)
+			}
+			break
+		}
+
+		tmp := jsonIndex{Stories: res}
+		if err = json.Unmarshal(in, &tmp); err != nil {
+			return h, nil, corrupt("JSON unmarshaling failed: %s", err)
 		}
+		h = tmp.Header
 	case types.Protobuf:
 		tmp := new(pbuff.PBAllStories)
 
 		err = proto.Unmarshal(in, tmp)
 		if err != nil {
-			log.Fatal("pbuff unmarshaling error: ", err)
+			return h, nil, corrupt("pbuff unmarshaling error: %s", err)
 		}
+		h = pbuff.HeaderFromPBHeader(tmp.Header)
 		res = pbuff.AllStoriesFromPBAllStories(tmp)
+	case types.PBStream, types.JSONLStream:
+		sr, err := newStreamReader(bytes.NewReader(in), t)
+		if err != nil {
+			return h, nil, err
+		}
+		defer sr.close()
+
+		h = sr.h
+		for {
+			s, err := sr.next()
+			if err != nil {
+				return h, nil, err
+			}
+			if s == nil {
+				break
+			}
+			res[s.Num] = s
+		}
 	default:
-		panic("Unsupported serializing method")
+		return h, nil, fmt.Errorf("%w: serialization `%s`", ErrUnsupportedFormat, t)
 	}
 
-	return res, err
+	return h, res, err
 }
 
-func read(idx types.IndexFile) (types.AllStories, error) {
+// read reads the index, upgrading it in memory if it has an older schema
+// version unless `mode` is readRaw, and tells whether it was upgraded. A
+// missing index is an empty one.
+func read(idx types.IndexFile, log types.Logger, mode openMode,
+) (
+	types.Header,
+	types.AllStories,
+	bool,
+	error,
+) {
 	var blob []byte
 	var a types.AllStories
+	var h types.Header
 	var err error
 
 	if blob, err = ioutil.ReadFile(idx.Location); err != nil {
+		if os.IsNotExist(err) {
+			return types.Header{SchemaVersion: schemaVersion}, types.AllStories{}, false, nil
+		}
+		return h, nil, false, err
+	}
+
+	if h, a, err = deserialize(blob, idx.Type); err != nil {
+		return h, nil, false, err
+	}
+	if mode == readRaw {
+		return h, a, false, nil
+	}
+
+	migrated, err := migrate(&h, a, idx.Location, log)
+	if err != nil {
+		return h, nil, false, err
+	}
+
+	return h, a, migrated, nil
+}
+
+// store writes the index, stamping the header with the current schema and
+// tool versions. The replaced version is optionally kept as a backup.
+func store(h *types.Header, a types.AllStories, idx types.IndexFile, withBackup bool) error {
+	var err error
+	var blob []byte
+
+	stamp(h)
+	if blob, err = serialize(*h, a, idx.Type, idx.Compression); err != nil {
+		return err
+	}
+
+	if err = writeFileAtomic(idx.Location, blob, idx.Mode.Perm(), withBackup); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// searchIndexLocation returns the location of the full-text index that
+// accompanies the given story index.
+func searchIndexLocation(idx types.IndexFile) string {
+	return idx.Location + ".search"
+}
+
+// buildSearchIndex builds the full-text index of the stories in the store,
+// reading them one by one.
+func buildSearchIndex(st Store) (*search.Index, error) {
+	res := search.New()
+
+	err := st.Iterate(func(s *types.Story) error {
+		res.Add(s.Num, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// readSearchIndex reads the full-text index for the stories in the store,
+// rebuilding it if it is missing or out of date.
+func readSearchIndex(st Store, idx types.IndexFile, log types.Logger) (*search.Index, error) {
+	var blob []byte
+	var err error
+
+	res := search.New()
+
+	blob, err = ioutil.ReadFile(searchIndexLocation(idx))
+	switch {
+	case os.IsNotExist(err):
+		log.Printf(types.LogNotice, "Full-text index not found, rebuilding it")
+		return buildSearchIndex(st)
+	case err != nil:
 		return nil, err
 	}
 
-	if a, err = deserialize(blob, idx.Type); err != nil {
+	if err = json.Unmarshal(blob, res); err != nil {
+		return nil, fmt.Errorf("Full-text index unmarshaling failed: %s", err)
+	}
+
+	count, digest := 0, uint64(0)
+	err = st.Iterate(func(s *types.Story) error {
+		count++
+		digest += search.Fingerprint(s.Num, s)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
+	if res.Len() != count || res.Digest() != digest {
+		log.Printf(types.LogNotice, "Full-text index is out of date, rebuilding it")
+		return buildSearchIndex(st)
+	}
 
-	return a, nil
+	return res, nil
 }
 
-func store(a types.AllStories, idx types.IndexFile) error {
+func storeSearchIndex(s *search.Index, idx types.IndexFile) error {
 	var err error
 	var blob []byte
 
-	if blob, err = serialize(a, idx.Type); err != nil {
-		return err
+	if blob, err = json.Marshal(s); err != nil {
+		return fmt.Errorf("Full-text index marshaling failed: %s", err)
 	}
 
-	if err = ioutil.WriteFile(idx.Location, blob, os.FileMode(0540)); err != nil {
+	err = writeFileAtomic(searchIndexLocation(idx), blob, idx.Mode.Perm(), false)
+	if err != nil {
 		return err
 	}
 
@@ -112,54 +362,522 @@ func filterByRange(a types.AllStories, rg types.Range) types.AllStories {
 	return res
 }
 
-func filterByQuery(a types.AllStories, query string) types.AllStories {
-	res := types.AllStories{}
+// filter decides whether a story is a hit, and scores it.
+type filter func(s *types.Story) (types.Hit, bool)
 
-	for k, v := range a {
-		if strings.Contains(v.Title, query) {
-			res[k] = a[k]
+// collectHits returns the hits among the stories of the iterator.
+func collectHits(ctx context.Context, it Iterator, f filter) (types.Hits, error) {
+	res := types.Hits{}
+
+	for it.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if h, ok := f(it.Story()); ok {
+			res = append(res, h)
 		}
 	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	sortHits(res)
 
-	return res
+	return res, nil
 }
 
-func Update(url string, rg types.Range, idx types.IndexFile) error {
-	var a types.AllStories
-	var err error
+// newTitleFilter returns a filter passing the stories whose titles match the
+// query, or all of them if the query is empty. Fuzzy matches are scored by
+// their similarity to the query.
+func newTitleFilter(query string, mp types.MatchParams) (filter, error) {
+	if query == "" {
+		return func(s *types.Story) (types.Hit, bool) {
+			return types.Hit{Story: s}, true
+		}, nil
+	}
 
-	fmt.Printf("Fetching from `%s`, range: `%s`, idx: `%s`\n", url, rg, idx)
+	m, err := match.New(query, mp)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(s *types.Story) (types.Hit, bool) {
+		score, ok := m(s.Title)
+		if mp.Mode != types.Fuzzy {
+			score = 0
+		}
+		return types.Hit{Story: s, Score: score}, ok
+	}, nil
+}
+
+// sortHits orders hits by decreasing score, and then by story number.
+func sortHits(h types.Hits) {
+	sort.Slice(h, func(i, j int) bool {
+		if h[i].Score != h[j].Score {
+			return h[i].Score > h[j].Score
+		}
+		return h[i].Story.Num < h[j].Story.Num
+	})
+}
 
-	if a, err = web.Fetch(url, rg); err != nil {
+// closeStore closes the store, reporting the failure through `err` unless an
+// earlier error is already there.
+func closeStore(st Store, err *error) {
+	if cerr := st.Close(); cerr != nil && *err == nil {
+		*err = fmt.Errorf("closing index failed: %w", cerr)
+	}
+}
+
+// writer opens the store of the index only for the duration of every write,
+// so that the network requests in between do not keep the index open. Bolt
+// indexes are locked while open, which would block all the readers. The index
+// is backed up only by the first write. Writers are meant to be used under
+// the lock of the index.
+type writer struct {
+	x        *Index
+	backedUp bool
+}
+
+// do calls fn with the store opened, and flushes and closes it afterwards.
+func (w *writer) do(fn func(st Store) error) error {
+	st, err := w.x.Open()
+	if err != nil {
 		return err
 	}
-	if err = store(a, idx); err != nil {
+	b, _ := st.(backupKeeper)
+	if b != nil && w.backedUp {
+		b.setBackedUp()
+	}
+
+	err = fn(st)
+	closeStore(st, &err)
+	if b != nil && b.wasBackedUp() {
+		w.backedUp = true
+	}
+
+	return err
+}
+
+// refreshSet returns the numbers of the `n` most recent stories from the
+// given range, out of the ascending story numbers.
+func refreshSet(nums []int, rg types.Range, n int) map[int]bool {
+	res := map[int]bool{}
+
+	for i := len(nums) - 1; i >= 0 && len(res) < n; i-- {
+		if nums[i] >= rg.Min && nums[i] <= rg.Max {
+			res[nums[i]] = true
+		}
+	}
+
+	return res
+}
+
+// reconcile compares a refetched story with the indexed one and returns the
+// fields that changed. The mirrored image is carried over unless the image
+// itself changed, and so is the revision history, as neither is part of the
+// fetched data. If anything changed, the indexed story becomes the latest
+// revision of the fetched one.
+func reconcile(old, fetched *types.Story, now time.Time) []types.FieldDiff {
+	if fetched.LocalImage == nil && fetched.Img == old.Img {
+		fetched.LocalImage = old.LocalImage
+	}
+	fetched.Revisions = old.Revisions
+
+	diffs := old.Diff(fetched)
+	if len(diffs) > 0 {
+		fetched.AddRevision(old, now)
+	}
+
+	return diffs
+}
+
+// Update fetches the stories from the given range that are missing from the
+// index and merges them into it, `url` being the address of the stories with
+// a %d in place of their numbers. The index is checkpointed periodically
+// while fetching and the stories fetched so far are stored even if fetching
+// fails or the context is cancelled, so an interrupted update resumes where
+// it stopped.
+func (x *Index) Update(ctx context.Context, url string, rg types.Range,
+	params types.FetchParams,
+) error {
+	_, err := x.update(ctx, url, rg, params, 0)
+	return err
+}
+
+// Sync works like Update, but also fetches again the `refresh` most recent
+// stories of the range to pick up edits, and reports all the stories that
+// were added or modified. Changes are not reported when the index was empty,
+// so that populating a new index does not produce one change per story.
+//
+// Cancelling the context stops fetching; the stories fetched until then are
+// stored and reported along with the context's error.
+func (x *Index) Sync(ctx context.Context, url string, rg types.Range,
+	params types.FetchParams, refresh int,
+) (
+	[]types.Change,
+	error,
+) {
+	return x.update(ctx, url, rg, params, refresh)
+}
+
+func (x *Index) update(ctx context.Context, url string, rg types.Range,
+	params types.FetchParams, refresh int,
+) (
+	changes []types.Change,
+	err error,
+) {
+	var nums []int
+	var fetched types.AllStories
+
+	x.logf(types.LogProgress, "Fetching from `%s`, range: `%s`, idx: `%s`, params: `%s`",
+		url, rg, x.File, params)
+
+	unlock, err := lockIndex(ctx, x.File, x.Logger)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// The store is opened only to read and write the stories, never while
+	// fetching them.
+	w := &writer{x: x}
+	err = w.do(func(st Store) error {
+		var err error
+		nums, err = storyNums(st)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	highest := 0
+	if len(nums) > 0 {
+		highest = nums[len(nums)-1]
+	}
+	x.logf(types.LogProgress, "Index contains %d stories, highest story is %d",
+		len(nums), highest)
+
+	// Only the refreshed stories are fetched again, and compared with the
+	// indexed ones.
+	initial := len(nums) == 0
+	refreshed := refreshSet(nums, rg, refresh)
+	known := map[int]bool{}
+	for _, k := range nums {
+		if !refreshed[k] {
+			known[k] = true
+		}
 	}
 
-	return nil
+	stored, saved := 0, map[int]bool{}
+	save := func(st Store, stories types.AllStories) error {
+		for _, k := range stories.Nums() {
+			if saved[k] {
+				continue
+			}
+			saved[k] = true
+
+			v, c := stories[k], types.Change{Story: stories[k]}
+			v.Script = transcript.Parse(v.Transcript)
+			if refreshed[k] {
+				old, err := st.Get(k)
+				if err != nil {
+					return err
+				}
+				if old != nil {
+					if c.Diffs = reconcile(old, v, time.Now()); len(c.Diffs) == 0 {
+						continue
+					}
+				}
+			}
+			if err := st.Put(v); err != nil {
+				return err
+			}
+			stored++
+			if !initial {
+				changes = append(changes, c)
+			}
+		}
+		return st.Flush()
+	}
+	checkpoint := func(partial types.AllStories) error {
+		x.logf(types.LogProgress, "Checkpointing %d fetched stories", len(partial))
+		return w.do(func(st Store) error {
+			return save(st, partial)
+		})
+	}
+
+	c := x.fetchClient(params)
+	before := c.Cache.Stats()
+	fetched, err = c.Fetch(ctx, url, rg, known, params, checkpoint)
+	x.logf(types.LogProgress, "Fetched %d stories", len(fetched))
+	if c.Cache != nil {
+		x.logf(types.LogProgress, "HTTP cache: %s", c.Cache.Stats().Sub(before))
+	}
+
+	// The index counts as synced only if everything was fetched.
+	synced := err == nil
+	var inRange types.AllStories
+	serr := w.do(func(st Store) error {
+		if err := save(st, fetched); err != nil {
+			return err
+		}
+		x.logf(types.LogProgress, "Stored %d new or modified stories", stored)
+
+		if stored > 0 {
+			x.logf(types.LogProgress, "Rebuilding full-text index")
+			s, err := buildSearchIndex(st)
+			if err == nil {
+				err = storeSearchIndex(s, x.File)
+			}
+			if err != nil {
+				return fmt.Errorf("storing full-text index failed: %w", err)
+			}
+		}
+
+		if !synced {
+			return nil
+		}
+		h := st.Header()
+		h.XkcdURI, h.LastSync = url, time.Now().UTC()
+		if err := st.SetHeader(h); err != nil {
+			return err
+		}
+		if params.WithImages {
+			var err error
+			inRange, err = st.Range(rg)
+			return err
+		}
+		return nil
+	})
+	if serr != nil {
+		if err != nil {
+			return changes, fmt.Errorf("%w, storing fetched stories failed too: %s", err, serr)
+		}
+		return changes, serr
+	}
+
+	if err == nil && params.WithImages {
+		err = x.mirrorImages(ctx, w, inRange, params.ImagesDir, params)
+	}
+
+	return changes, err
 }
 
-func Fetch(query string, rg types.Range, idx types.IndexFile) (types.AllStories, error) {
-	var a types.AllStories
-	var err error
+// FilterStories returns the stories from the given range whose titles match
+// the query, or all of them if the query is empty. Fuzzy matches are ordered
+// by decreasing similarity, everything else by story number.
+func FilterStories(a types.AllStories, query string, mp types.MatchParams,
+	rg types.Range,
+) (
+	types.Hits,
+	error,
+) {
+	f, err := newTitleFilter(query, mp)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectHits(context.Background(), newMapIterator(a, rg), f)
+}
+
+// List reads the stories from the given range one by one and filters them
+// like FilterStories.
+func (x *Index) List(ctx context.Context, query string, mp types.MatchParams,
+	rg types.Range,
+) (
+	res types.Hits,
+	err error,
+) {
+	var st Store
+	var it Iterator
+
+	x.logf(types.LogProgress, "Listing entries, "+
+		"query: `%s`, "+
+		"match: `%s`, "+
+		"range: `%s`, "+
+		"idx: `%s`", query, mp, rg, x.File)
+
+	f, err := newTitleFilter(query, mp)
+	if err != nil {
+		return nil, err
+	}
+
+	if st, err = x.OpenReadOnly(); err != nil {
+		return nil, err
+	}
+	defer closeStore(st, &err)
+
+	if it, err = st.Stories(rg); err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	return collectHits(ctx, it, f)
+}
 
-	fmt.Printf("Listing entries, "+
+// newSearchFilter returns a filter passing the stories matching the parsed
+// query, scored by the relevance of its free-text terms according to the
+// full-text index `s`.
+func newSearchFilter(q *query.Query, s *search.Index) filter {
+	scores := map[int]float64{}
+	if text := q.Text(); text != "" {
+		for _, r := range s.Search(text) {
+			scores[r.Num] = r.Score
+		}
+	}
+
+	return func(v *types.Story) (types.Hit, bool) {
+		return types.Hit{Story: v, Score: scores[v.Num]}, q.Match(v)
+	}
+}
+
+// SearchStories returns the stories from the given range matching the query,
+// see package query for the syntax. Stories are ordered by decreasing
+// relevance of the free-text terms of the query, as scored by the full-text
+// index `s`.
+func SearchStories(a types.AllStories, s *search.Index, queryString string,
+	rg types.Range,
+) (
+	types.Hits,
+	error,
+) {
+	q, err := query.Parse(queryString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err)
+	}
+
+	return collectHits(context.Background(), newMapIterator(a, rg), newSearchFilter(q, s))
+}
+
+// Load reads all the stories of the index along with its full-text index.
+func (x *Index) Load(ctx context.Context,
+) (
+	a types.AllStories,
+	s *search.Index,
+	err error,
+) {
+	var st Store
+
+	if st, err = x.OpenReadOnly(); err != nil {
+		return nil, nil, err
+	}
+	defer closeStore(st, &err)
+
+	if a, err = readAll(st); err != nil {
+		return nil, nil, err
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if s, err = readSearchIndex(st, x.File, x.Logger); err != nil {
+		return nil, nil, err
+	}
+
+	return a, s, nil
+}
+
+// Search searches the index like SearchStories, reading the stories one by
+// one.
+func (x *Index) Search(ctx context.Context, queryString string, rg types.Range,
+) (
+	res types.Hits,
+	err error,
+) {
+	var st Store
+	var s *search.Index
+	var q *query.Query
+	var it Iterator
+
+	x.logf(types.LogProgress, "Searching entries, "+
 		"query: `%s`, "+
 		"range: `%s`, "+
-		"idx: `%s`\n", query, rg, idx)
+		"idx: `%s`", queryString, rg, x.File)
+
+	if q, err = query.Parse(queryString); err != nil {
+		return nil, fmt.Errorf("invalid query: %s", err)
+	}
 
-	if a, err = read(idx); err != nil {
+	if st, err = x.OpenReadOnly(); err != nil {
 		return nil, err
 	}
+	defer closeStore(st, &err)
 
-	if query != "" {
-		a = filterByQuery(a, query)
+	if s, err = readSearchIndex(st, x.File, x.Logger); err != nil {
+		return nil, err
 	}
 
-	if rg.Min > 0 || rg.Max > 0 {
-		a = filterByRange(a, rg)
+	if it, err = st.Stories(rg); err != nil {
+		return nil, err
 	}
+	defer it.Close()
 
-	return a, nil
+	return collectHits(ctx, it, newSearchFilter(q, s))
+}
+
+// Get returns the story with the given number, ErrNotFound if there is no
+// such story in the index.
+func (x *Index) Get(ctx context.Context, num int) (s *types.Story, err error) {
+	var st Store
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if st, err = x.OpenReadOnly(); err != nil {
+		return nil, err
+	}
+	defer closeStore(st, &err)
+
+	if s, err = st.Get(num); err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("story %d: %w", num, ErrNotFound)
+	}
+
+	return s, nil
+}
+
+// Random returns a random story from the given range matching the query, see
+// Search, ErrNotFound if there is none. An empty query matches all the
+// stories.
+func (x *Index) Random(ctx context.Context, queryString string, rg types.Range,
+) (
+	*types.Story,
+	error,
+) {
+	h, err := x.Search(ctx, queryString, rg)
+	if err != nil {
+		return nil, err
+	}
+	if len(h) == 0 {
+		return nil, fmt.Errorf("stories matching `%s`: %w", queryString, ErrNotFound)
+	}
+
+	return h[rand.Intn(len(h))].Story, nil
+}
+
+// History prints all the revisions of the story with the given number, along
+// with the fields that changed between consecutive ones.
+func (x *Index) History(ctx context.Context, w io.Writer, num int) error {
+	s, err := x.Get(ctx, num)
+	if err != nil {
+		return err
+	}
+
+	versions := s.Versions()
+	fmt.Fprintf(w, "Story %d: %s, %d revisions\n", num, s.Title, len(versions))
+	for i, v := range versions {
+		if i == len(versions)-1 {
+			fmt.Fprintf(w, "Revision %d: current\n", i+1)
+		} else {
+			fmt.Fprintf(w, "Revision %d: replaced at %s\n",
+				i+1, v.Replaced.Local().Format(time.RFC3339))
+		}
+		if i == 0 {
+			continue
+		}
+		for _, d := range versions[i-1].Story.Diff(v.Story) {
+			fmt.Fprintf(w, "\t%s\n", d)
+		}
+	}
+
+	return nil
 }
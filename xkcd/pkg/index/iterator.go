@@ -0,0 +1,178 @@
+package index
+
+import (
+	"context"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// Iterator reads stories one at a time, in ascending order of story numbers:
+//
+//	for it.Next() {
+//		s := it.Story()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator interface {
+	// Next advances to the next story, returning false at the end or on
+	// error.
+	Next() bool
+	// Story returns the current story.
+	Story() *types.Story
+	// Err returns the error that stopped the iteration, if any.
+	Err() error
+	// Close releases the resources held by the iterator, it has to be called
+	// even if the iteration did not finish.
+	Close() error
+}
+
+// funcIterator adapts a function returning the next story, or nil at the end.
+type funcIterator struct {
+	next  func() (*types.Story, error)
+	close func() error
+	cur   *types.Story
+	err   error
+}
+
+func (f *funcIterator) Next() bool {
+	if f.err != nil {
+		return false
+	}
+
+	f.cur, f.err = f.next()
+	return f.err == nil && f.cur != nil
+}
+
+func (f *funcIterator) Story() *types.Story {
+	return f.cur
+}
+
+func (f *funcIterator) Err() error {
+	return f.err
+}
+
+func (f *funcIterator) Close() error {
+	if f.close == nil {
+		return nil
+	}
+	return f.close()
+}
+
+// newMapIterator iterates over the stories of the map that are in the range.
+func newMapIterator(a types.AllStories, rg types.Range) Iterator {
+	nums := filterByRange(a, rg).Nums()
+
+	return &funcIterator{next: func() (*types.Story, error) {
+		if len(nums) == 0 {
+			return nil, nil
+		}
+		s := a[nums[0]]
+		nums = nums[1:]
+		return s, nil
+	}}
+}
+
+// mergeIterator merges two iterators, the stories of `over` replacing the
+// ones of `base` with the same numbers.
+type mergeIterator struct {
+	base, over       Iterator
+	nextBase, nextOv *types.Story
+	started          bool
+	cur              *types.Story
+	err              error
+}
+
+func newMergeIterator(base, over Iterator) Iterator {
+	return &mergeIterator{base: base, over: over}
+}
+
+func (m *mergeIterator) advance(it Iterator) *types.Story {
+	if it.Next() {
+		return it.Story()
+	}
+	if err := it.Err(); err != nil && m.err == nil {
+		m.err = err
+	}
+	return nil
+}
+
+func (m *mergeIterator) Next() bool {
+	if !m.started {
+		m.nextBase, m.nextOv = m.advance(m.base), m.advance(m.over)
+		m.started = true
+	}
+	if m.err != nil {
+		return false
+	}
+
+	b, o := m.nextBase, m.nextOv
+	switch {
+	case b == nil && o == nil:
+		return false
+	case b == nil || (o != nil && o.Num <= b.Num):
+		if b != nil && b.Num == o.Num {
+			m.nextBase = m.advance(m.base)
+		}
+		m.cur, m.nextOv = o, m.advance(m.over)
+	default:
+		m.cur, m.nextBase = b, m.advance(m.base)
+	}
+
+	return m.err == nil
+}
+
+func (m *mergeIterator) Story() *types.Story {
+	return m.cur
+}
+
+func (m *mergeIterator) Err() error {
+	return m.err
+}
+
+func (m *mergeIterator) Close() error {
+	err := m.base.Close()
+	if oerr := m.over.Close(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+// allStories is the range covering every story.
+var allStories = types.FullRange
+
+// Stories returns an iterator over the stories of the index from the given
+// range. For the streaming formats, only a single story is held in memory at
+// a time. Iteration stops with the context's error once it is cancelled.
+func (x *Index) Stories(ctx context.Context, rg types.Range) (Iterator, error) {
+	st, err := x.OpenReadOnly()
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := st.Stories(rg)
+	if err != nil {
+		st.Close()
+		return nil, err
+	}
+
+	return &funcIterator{
+		next: func() (*types.Story, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if it.Next() {
+				return it.Story(), nil
+			}
+			return nil, it.Err()
+		},
+		close: func() error {
+			err := it.Close()
+			if cerr := st.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		},
+	}, nil
+}
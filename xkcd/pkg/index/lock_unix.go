@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package index
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// lockPollInterval is how often a lock held by another process is retried.
+const lockPollInterval = 100 * time.Millisecond
+
+// lockIndex takes an exclusive advisory lock on the index, waiting for other
+// processes holding it to finish or the context to be cancelled. The returned
+// function releases the lock.
+func lockIndex(ctx context.Context, idx types.IndexFile, log types.Logger,
+) (
+	func(),
+	error,
+) {
+	// flock works on read-only descriptors, so the lock file does not need to
+	// be writable even if the index is not.
+	f, err := os.OpenFile(lockLocation(idx.Location), os.O_RDONLY|os.O_CREATE,
+		idx.Mode.Perm()|0400)
+	if err != nil {
+		return nil, fmt.Errorf("opening index lock failed: %s", err)
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		log.Printf(types.LogNotice, "Index `%s` is locked by another process, waiting",
+			idx.Location)
+	}
+	for err == syscall.EWOULDBLOCK {
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking index failed: %s", err)
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
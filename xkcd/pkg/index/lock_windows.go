@@ -0,0 +1,60 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+	"golang.org/x/sys/windows"
+)
+
+// lockPollInterval is how often a lock held by another process is retried.
+const lockPollInterval = 100 * time.Millisecond
+
+// lockFile tries to take an exclusive lock on the whole file without waiting.
+func lockFile(f *os.File) error {
+	return windows.LockFileEx(windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{})
+}
+
+// lockIndex takes an exclusive lock on the index, waiting for other processes
+// holding it to finish or the context to be cancelled. The returned function
+// releases the lock.
+func lockIndex(ctx context.Context, idx types.IndexFile, log types.Logger,
+) (
+	func(),
+	error,
+) {
+	f, err := os.OpenFile(lockLocation(idx.Location), os.O_RDONLY|os.O_CREATE,
+		idx.Mode.Perm()|0400)
+	if err != nil {
+		return nil, fmt.Errorf("opening index lock failed: %s", err)
+	}
+
+	err = lockFile(f)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		log.Printf(types.LogNotice, "Index `%s` is locked by another process, waiting",
+			idx.Location)
+	}
+	for err == windows.ERROR_LOCK_VIOLATION {
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+		err = lockFile(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking index failed: %s", err)
+	}
+
+	return func() {
+		windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{})
+		f.Close()
+	}, nil
+}
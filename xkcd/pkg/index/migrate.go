@@ -0,0 +1,92 @@
+package index
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/transcript"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// migration upgrades an index from the schema version before `version` to
+// `version`.
+type migration struct {
+	version int
+	desc    string
+	apply   func(h *types.Header, a types.AllStories) error
+}
+
+// migrations lists all the schema changes, in order. To change the schema,
+// append a migration that upgrades the stories written with the previous one.
+var migrations = []migration{
+	{
+		version: 1,
+		desc:    "add the index header",
+		apply: func(h *types.Header, a types.AllStories) error {
+			// The header itself is all that is new, and the time the index
+			// was created was not recorded.
+			h.Created = time.Now().UTC()
+			return nil
+		},
+	},
+	{
+		version: 2,
+		desc:    "parse the transcripts",
+		apply: func(h *types.Header, a types.AllStories) error {
+			for _, s := range a {
+				s.Script = transcript.Parse(s.Transcript)
+			}
+			return nil
+		},
+	},
+}
+
+// schemaVersion is the version of the indexes written by this version of
+// xkcd.
+var schemaVersion = migrations[len(migrations)-1].version
+
+// migrate upgrades the index to the current schema version, returning whether
+// anything had to be done. Indexes written by newer versions of xkcd are
+// rejected, as they may contain data this version would lose.
+func migrate(h *types.Header, a types.AllStories, location string, log types.Logger,
+) (
+	bool,
+	error,
+) {
+	if h.SchemaVersion > schemaVersion {
+		return false, fmt.Errorf("%w: index `%s` has schema version %d, newer than "+
+			"the supported %d, xkcd needs to be upgraded", ErrUnsupportedFormat, location,
+			h.SchemaVersion, schemaVersion)
+	}
+
+	migrated := false
+	for _, m := range migrations {
+		if m.version <= h.SchemaVersion {
+			continue
+		}
+
+		log.Printf(types.LogNotice, "Upgrading index `%s` to schema version %d: %s",
+			location, m.version, m.desc)
+		if err := m.apply(h, a); err != nil {
+			return false, fmt.Errorf("upgrading index to schema version %d failed: %s",
+				m.version, err)
+		}
+		h.SchemaVersion = m.version
+		migrated = true
+	}
+
+	return migrated, nil
+}
+
+// stamp records in the header that the index is being written by this
+// version of xkcd.
+func stamp(h *types.Header) {
+	now := time.Now().UTC()
+
+	h.SchemaVersion = schemaVersion
+	h.ToolVersion = types.ToolVersion
+	h.Updated = now
+	if h.Created.IsZero() {
+		h.Created = now
+	}
+}
@@ -0,0 +1,143 @@
+package index
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/images"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// mirrorImages downloads the images of all the given stories that are not in
+// the image store yet and records them in the index. The index is opened only
+// to record the mirrored images, every `params.CheckpointEvery` of them and
+// once done.
+func (x *Index) mirrorImages(ctx context.Context, w *writer, a types.AllStories,
+	dir string, params types.FetchParams,
+) (err error) {
+	var failed int
+	var pending []*types.Story
+
+	record := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		err := w.do(func(st Store) error {
+			for _, s := range pending {
+				if err := st.Put(s); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		pending = nil
+		return err
+	}
+	defer func() {
+		if rerr := record(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}()
+
+	mirrored := 0
+	for _, k := range a.Nums() {
+		s := a[k]
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		changed, err := images.Mirror(ctx, x.client(), dir, s, params.Retries)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			x.logf(types.LogNotice, "Mirroring image of story %d failed: %s", k, err)
+			failed++
+			continue
+		}
+		if !changed {
+			continue
+		}
+		pending = append(pending, s)
+
+		mirrored++
+		if params.CheckpointEvery > 0 && mirrored%params.CheckpointEvery == 0 {
+			if err = record(); err != nil {
+				return err
+			}
+		}
+	}
+	x.logf(types.LogProgress, "Mirrored %d images", mirrored)
+
+	if failed > 0 {
+		return fmt.Errorf("mirroring %d images failed", failed)
+	}
+	return nil
+}
+
+// Mirror downloads the images of all the stories in the given range that are
+// not in the image store in `dir` yet, and records them in the index.
+func (x *Index) Mirror(ctx context.Context, rg types.Range, dir string,
+	params types.FetchParams,
+) (err error) {
+	var a types.AllStories
+
+	x.logf(types.LogProgress, "Mirroring images, range: `%s`, idx: `%s`, images: `%s`",
+		rg, x.File, dir)
+
+	unlock, err := lockIndex(ctx, x.File, x.Logger)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	w := &writer{x: x}
+	err = w.do(func(st Store) error {
+		var err error
+		a, err = st.Range(rg)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return x.mirrorImages(ctx, w, a, dir, params)
+}
+
+// VerifyImages checks the mirrored images of all the stories in the given
+// range against their recorded checksums.
+func (x *Index) VerifyImages(ctx context.Context, rg types.Range, dir string,
+) (err error) {
+	var st Store
+	var a types.AllStories
+
+	if st, err = x.OpenReadOnly(); err != nil {
+		return err
+	}
+	defer closeStore(st, &err)
+
+	if a, err = st.Range(rg); err != nil {
+		return err
+	}
+
+	checked, bad := 0, 0
+	for _, k := range a.Nums() {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if a[k].Img == "" {
+			continue
+		}
+		checked++
+		if verr := images.Verify(dir, a[k]); verr != nil {
+			x.logf(types.LogNotice, "%s", verr)
+			bad++
+		}
+	}
+	x.logf(types.LogProgress, "Verified %d images, %d problems found", checked, bad)
+
+	if bad > 0 {
+		return fmt.Errorf("%d images failed verification", bad)
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// Store is the on-disk storage of the stories.
+type Store interface {
+	// Get returns the story with the given number, or nil if there is no
+	// such story.
+	Get(num int) (*types.Story, error)
+	// Put adds a story, replacing the one with the same number if present.
+	Put(s *types.Story) error
+	// Range returns all the stories with numbers from rg.Min to rg.Max
+	// inclusive.
+	Range(rg types.Range) (types.AllStories, error)
+	// Iterate calls fn for every story in ascending order of story numbers,
+	// stopping at the first error.
+	Iterate(fn func(s *types.Story) error) error
+	// Stories returns an iterator over the stories with numbers from rg.Min
+	// to rg.Max inclusive.
+	Stories(rg types.Range) (Iterator, error)
+	// Header returns the description of the index.
+	Header() types.Header
+	// SetHeader replaces the description of the index. The schema and tool
+	// versions and the update time are set by the store when writing.
+	SetHeader(h types.Header) error
+	// Flush makes sure all the stories that were Put, and the header, are
+	// persisted. The index is backed up before it is first modified, so
+	// the backup is the version the store was opened with.
+	Flush() error
+	// Close flushes the store and releases all of its resources.
+	Close() error
+}
+
+// openMode tells what a store may do with the index file.
+type openMode int
+
+const (
+	// readWrite stores upgrade indexes with an older schema version, the
+	// upgrade is written with the first flush. They are meant to be opened
+	// under the lock of the index.
+	readWrite openMode = iota
+	// readOnly stores never write to the index file, indexes with an older
+	// schema version are upgraded in memory.
+	readOnly
+	// readRaw stores are read-only and return the index as it is stored,
+	// without upgrading it.
+	readRaw
+)
+
+// errReadOnly is returned when modifying a store opened read-only.
+var errReadOnly = errors.New("the index is opened read-only")
+
+// Open opens the store for the given index, creating it if it does not
+// exist. The implementation is chosen by the format of the existing index
+// file, or by the index type for new indexes. Indexes with an older schema
+// version are upgraded, which is reported to the logger and written with the
+// first flush, so the index should be locked by the caller.
+func Open(idx types.IndexFile, log types.Logger) (Store, error) {
+	return open(idx, log, readWrite)
+}
+
+// OpenReadOnly opens the store for the given index without ever writing to
+// it, so that no lock is needed. Indexes with an older schema version are
+// upgraded in memory, and a missing index is an empty one. Modifying the
+// store fails.
+func OpenReadOnly(idx types.IndexFile, log types.Logger) (Store, error) {
+	return open(idx, log, readOnly)
+}
+
+func open(idx types.IndexFile, log types.Logger, mode openMode) (Store, error) {
+	var err error
+
+	if idx, err = resolveType(idx, log); err != nil {
+		return nil, err
+	}
+
+	switch idx.Type {
+	case types.JSON, types.Protobuf:
+		return openFileStore(idx, log, mode)
+	case types.Bolt:
+		return openBoltStore(idx, log, mode)
+	case types.PBStream, types.JSONLStream:
+		return openStreamStore(idx, log, mode)
+	default:
+		return nil, fmt.Errorf("%w: index type `%s`", ErrUnsupportedFormat, idx.Type)
+	}
+}
+
+// keyIterator is implemented by stores that can list the stories along with
+// the keys they are stored under. Normally the keys are the story numbers, but
+// a damaged index may disagree, which Iterate does not reveal.
+type keyIterator interface {
+	IterateKeys(fn func(key int, s *types.Story) error) error
+}
+
+// numLister is implemented by stores that can list the numbers of their
+// stories without decoding them.
+type numLister interface {
+	// Nums returns the numbers of the stories in ascending order.
+	Nums() ([]int, error)
+}
+
+// backupKeeper is implemented by stores that back the index up before they
+// first modify it. Operations opening the store several times mark the later
+// stores as backed up, so that the backup stays the version the operation
+// started with.
+type backupKeeper interface {
+	// wasBackedUp tells whether the index was already backed up.
+	wasBackedUp() bool
+	// setBackedUp keeps the store from backing the index up again.
+	setBackedUp()
+}
+
+// storyNums returns the numbers of the stories in the store, in ascending
+// order.
+func storyNums(st Store) ([]int, error) {
+	if l, ok := st.(numLister); ok {
+		return l.Nums()
+	}
+
+	var res []int
+	err := st.Iterate(func(s *types.Story) error {
+		res = append(res, s.Num)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// readAll returns all the stories in the store.
+func readAll(st Store) (types.AllStories, error) {
+	res := types.AllStories{}
+
+	err := st.Iterate(func(s *types.Story) error {
+		res[s.Num] = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// fileStore keeps the whole index in memory and writes it back to a single
+// JSON or protobuf file when flushed. Read-only stores of the other formats
+// are fileStores too when the index had to be upgraded in memory.
+type fileStore struct {
+	idx      types.IndexFile
+	h        types.Header
+	a        types.AllStories
+	dirty    bool
+	readOnly bool
+	// backedUp tells whether the index was already backed up.
+	backedUp bool
+}
+
+func openFileStore(idx types.IndexFile, log types.Logger, mode openMode) (*fileStore, error) {
+	h, a, migrated, err := read(idx, log, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileStore{
+		idx:      idx,
+		h:        h,
+		a:        a,
+		dirty:    migrated && mode == readWrite,
+		readOnly: mode != readWrite,
+	}, nil
+}
+
+// memStore returns a read-only store of the stories.
+func memStore(h types.Header, a types.AllStories) *fileStore {
+	return &fileStore{h: h, a: a, readOnly: true}
+}
+
+func (f *fileStore) Get(num int) (*types.Story, error) {
+	return f.a[num], nil
+}
+
+func (f *fileStore) Put(s *types.Story) error {
+	if f.readOnly {
+		return errReadOnly
+	}
+	f.a[s.Num] = s
+	f.dirty = true
+	return nil
+}
+
+func (f *fileStore) Nums() ([]int, error) {
+	return f.a.Nums(), nil
+}
+
+func (f *fileStore) Range(rg types.Range) (types.AllStories, error) {
+	return filterByRange(f.a, rg), nil
+}
+
+func (f *fileStore) Iterate(fn func(s *types.Story) error) error {
+	for _, k := range f.a.Nums() {
+		if err := fn(f.a[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fileStore) Stories(rg types.Range) (Iterator, error) {
+	return newMapIterator(f.a, rg), nil
+}
+
+func (f *fileStore) IterateKeys(fn func(key int, s *types.Story) error) error {
+	for _, k := range f.a.Nums() {
+		if err := fn(k, f.a[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fileStore) Header() types.Header {
+	return f.h
+}
+
+func (f *fileStore) SetHeader(h types.Header) error {
+	if f.readOnly {
+		return errReadOnly
+	}
+	f.h = h
+	f.dirty = true
+	return nil
+}
+
+func (f *fileStore) Flush() error {
+	if !f.dirty {
+		return nil
+	}
+	if err := store(&f.h, f.a, f.idx, !f.backedUp); err != nil {
+		return err
+	}
+	f.dirty, f.backedUp = false, true
+	return nil
+}
+
+func (f *fileStore) wasBackedUp() bool { return f.backedUp }
+
+func (f *fileStore) setBackedUp() { f.backedUp = true }
+
+func (f *fileStore) Close() error {
+	return f.Flush()
+}
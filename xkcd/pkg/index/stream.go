@@ -0,0 +1,446 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/vespian/go-exercises/xkcd/pkg/pbuff"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// pbStreamMagic starts protobuf stream indexes. It is followed by the
+// length-delimited PBHeader and a length-delimited PBStory per story.
+var pbStreamMagic = []byte("XKCDPBS\n")
+
+// jsonlFormat marks JSON Lines indexes, whose first line is a jsonlHeader,
+// followed by a line per story.
+const jsonlFormat = "xkcd-jsonl"
+
+// maxRecordLen limits the size of a single protobuf stream record, so that a
+// corrupted length does not exhaust the memory.
+const maxRecordLen = 64 << 20
+
+type jsonlHeader struct {
+	Format string       `json:"format"`
+	Header types.Header `json:"header"`
+}
+
+func isPBStream(header []byte) bool {
+	return bytes.HasPrefix(header, pbStreamMagic)
+}
+
+func isJSONLStream(header []byte) bool {
+	trimmed := bytes.TrimLeft(header, " \t\r\n")
+	return bytes.HasPrefix(trimmed, []byte(`{"format":"`+jsonlFormat+`"`))
+}
+
+// streamWriter encodes an index as a stream of records, with the stories in
+// ascending order of their numbers.
+type streamWriter struct {
+	t    types.OndiskSerialization
+	zw   io.WriteCloser
+	bw   *bufio.Writer
+	last int
+}
+
+func newStreamWriter(w io.Writer, t types.OndiskSerialization, c types.Compression,
+	h types.Header,
+) (
+	*streamWriter,
+	error,
+) {
+	zw, err := newCompressor(w, c)
+	if err != nil {
+		return nil, err
+	}
+	sw := &streamWriter{t: t, zw: zw, bw: bufio.NewWriter(zw), last: -1}
+
+	switch t {
+	case types.PBStream:
+		if _, err = sw.bw.Write(pbStreamMagic); err == nil {
+			err = sw.writeMessage(pbuff.PBHeaderFromHeader(h))
+		}
+	case types.JSONLStream:
+		err = sw.writeLine(jsonlHeader{Format: jsonlFormat, Header: h})
+	default:
+		err = fmt.Errorf("%w: stream serialization `%s`", ErrUnsupportedFormat, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return sw, nil
+}
+
+func (sw *streamWriter) writeMessage(m proto.Message) error {
+	blob, err := proto.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("pbuff marshaling error: %s", err)
+	}
+
+	var lb [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lb[:], uint64(len(blob)))
+	if _, err = sw.bw.Write(lb[:n]); err != nil {
+		return err
+	}
+	_, err = sw.bw.Write(blob)
+	return err
+}
+
+func (sw *streamWriter) writeLine(v interface{}) error {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("JSON marshaling failed: %s", err)
+	}
+
+	if _, err = sw.bw.Write(blob); err != nil {
+		return err
+	}
+	return sw.bw.WriteByte('\n')
+}
+
+// write appends the story, which has to have a higher number than all the
+// stories written before.
+func (sw *streamWriter) write(s *types.Story) error {
+	if s.Num <= sw.last {
+		return fmt.Errorf("story %d written after story %d", s.Num, sw.last)
+	}
+	sw.last = s.Num
+
+	if sw.t == types.PBStream {
+		return sw.writeMessage(pbuff.PBStoryFromStory(s))
+	}
+	return sw.writeLine(s)
+}
+
+// close flushes all the records, it does not close the underlying writer.
+func (sw *streamWriter) close() error {
+	err := sw.bw.Flush()
+	if cerr := sw.zw.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// streamReader decodes an index written by streamWriter, one story at a time.
+type streamReader struct {
+	t   types.OndiskSerialization
+	zr  io.ReadCloser
+	br  *bufio.Reader
+	dec *json.Decoder
+	h   types.Header
+}
+
+func newStreamReader(r io.Reader, t types.OndiskSerialization) (*streamReader, error) {
+	zr, err := newDecompressor(r)
+	if err != nil {
+		return nil, err
+	}
+	sr := &streamReader{t: t, zr: zr, br: bufio.NewReader(zr)}
+
+	switch t {
+	case types.PBStream:
+		magic := make([]byte, len(pbStreamMagic))
+		if _, err = io.ReadFull(sr.br, magic); err != nil || !isPBStream(magic) {
+			err = fmt.Errorf("not a protobuf stream index")
+			break
+		}
+		tmp := new(pbuff.PBHeader)
+		var ok bool
+		if ok, err = sr.readMessage(tmp); err == nil && !ok {
+			err = fmt.Errorf("protobuf stream index header is missing")
+		}
+		sr.h = pbuff.HeaderFromPBHeader(tmp)
+	case types.JSONLStream:
+		var tmp jsonlHeader
+		sr.dec = json.NewDecoder(sr.br)
+		if err = sr.dec.Decode(&tmp); err == nil && tmp.Format != jsonlFormat {
+			err = fmt.Errorf("not a JSON Lines index")
+		}
+		sr.h = tmp.Header
+	default:
+		err = fmt.Errorf("%w: stream serialization `%s`", ErrUnsupportedFormat, t)
+	}
+	if err != nil {
+		zr.Close()
+		return nil, corrupt("reading index header failed: %s", err)
+	}
+
+	return sr, nil
+}
+
+// readMessage reads the next length-delimited record, returning false at the
+// end of the stream.
+func (sr *streamReader) readMessage(m proto.Message) (bool, error) {
+	n, err := binary.ReadUvarint(sr.br)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, corrupt("%s", err)
+	}
+	if n > maxRecordLen {
+		return false, corrupt("record of %d bytes is too long", n)
+	}
+
+	blob := make([]byte, n)
+	if _, err = io.ReadFull(sr.br, blob); err != nil {
+		return false, corrupt("truncated record: %s", err)
+	}
+	if err = proto.Unmarshal(blob, m); err != nil {
+		return false, corrupt("pbuff unmarshaling error: %s", err)
+	}
+
+	return true, nil
+}
+
+// next returns the next story, or nil at the end of the stream.
+func (sr *streamReader) next() (*types.Story, error) {
+	if sr.t == types.PBStream {
+		tmp := new(pbuff.PBStory)
+		ok, err := sr.readMessage(tmp)
+		if !ok || err != nil {
+			return nil, err
+		}
+		return pbuff.StoryFromPBStory(tmp), nil
+	}
+
+	s := new(types.Story)
+	err := sr.dec.Decode(s)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, corrupt("JSON unmarshaling failed: %s", err)
+	}
+	return s, nil
+}
+
+func (sr *streamReader) close() error {
+	return sr.zr.Close()
+}
+
+// streamStore reads the stories of a stream index straight from the file.
+// Stories that were Put are kept in memory until Flush merges them into a new
+// version of the file, so that the whole index is never loaded at once.
+type streamStore struct {
+	idx      types.IndexFile
+	h        types.Header
+	pending  types.AllStories
+	dirty    bool
+	readOnly bool
+	// backedUp tells whether the index was already backed up.
+	backedUp bool
+}
+
+// openStreamStore opens the index. Upgrading an index with an older schema
+// version needs the whole index, so read-only stores of such indexes are
+// kept in memory.
+func openStreamStore(idx types.IndexFile, log types.Logger, mode openMode) (Store, error) {
+	st := &streamStore{idx: idx, pending: types.AllStories{}, readOnly: mode != readWrite}
+
+	f, sr, err := st.open()
+	if err != nil {
+		return nil, err
+	}
+	if sr == nil {
+		st.h = types.Header{SchemaVersion: schemaVersion}
+		return st, nil
+	}
+	st.h = sr.h
+	sr.close()
+	f.Close()
+
+	if st.h.SchemaVersion == schemaVersion || mode == readRaw {
+		return st, nil
+	}
+
+	// Migrations work on the whole index.
+	a, err := readAll(st)
+	if err != nil {
+		return nil, err
+	}
+	migrated, err := migrate(&st.h, a, idx.Location, log)
+	if err != nil {
+		return nil, err
+	}
+	if mode == readOnly {
+		return memStore(st.h, a), nil
+	}
+	if migrated {
+		// The upgrade is written with the first flush.
+		st.pending, st.dirty = a, true
+	}
+
+	return st, nil
+}
+
+// open opens the index file for reading, returning nils if it does not exist.
+func (st *streamStore) open() (*os.File, *streamReader, error) {
+	f, err := os.Open(st.idx.Location)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sr, err := newStreamReader(f, st.idx.Type)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, sr, nil
+}
+
+// fileStories iterates over the stories from the given range stored in the
+// file, ignoring the pending ones.
+func (st *streamStore) fileStories(rg types.Range) (Iterator, error) {
+	f, sr, err := st.open()
+	if err != nil {
+		return nil, err
+	}
+	if sr == nil {
+		return newMapIterator(nil, rg), nil
+	}
+
+	return &funcIterator{
+		next: func() (*types.Story, error) {
+			for {
+				s, err := sr.next()
+				if s == nil || err != nil || s.Num > rg.Max {
+					return nil, err
+				}
+				if s.Num >= rg.Min {
+					return s, nil
+				}
+			}
+		},
+		close: func() error {
+			sr.close()
+			return f.Close()
+		},
+	}, nil
+}
+
+func (st *streamStore) Stories(rg types.Range) (Iterator, error) {
+	it, err := st.fileStories(rg)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMergeIterator(it, newMapIterator(st.pending, rg)), nil
+}
+
+func (st *streamStore) Get(num int) (*types.Story, error) {
+	if s, ok := st.pending[num]; ok {
+		return s, nil
+	}
+
+	it, err := st.fileStories(types.Range{Min: num, Max: num})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	if it.Next() {
+		return it.Story(), nil
+	}
+	return nil, it.Err()
+}
+
+func (st *streamStore) Put(s *types.Story) error {
+	if st.readOnly {
+		return errReadOnly
+	}
+	st.pending[s.Num] = s
+	st.dirty = true
+	return nil
+}
+
+func (st *streamStore) Range(rg types.Range) (types.AllStories, error) {
+	res := types.AllStories{}
+
+	err := st.iterate(rg, func(s *types.Story) error {
+		res[s.Num] = s
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (st *streamStore) iterate(rg types.Range, fn func(s *types.Story) error) error {
+	it, err := st.Stories(rg)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	for it.Next() {
+		if err = fn(it.Story()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+func (st *streamStore) Iterate(fn func(s *types.Story) error) error {
+	return st.iterate(allStories, fn)
+}
+
+func (st *streamStore) Header() types.Header {
+	return st.h
+}
+
+func (st *streamStore) SetHeader(h types.Header) error {
+	if st.readOnly {
+		return errReadOnly
+	}
+	st.h = h
+	st.dirty = true
+	return nil
+}
+
+// Flush writes a new version of the file, merging the pending stories into
+// the stored ones as they are read.
+func (st *streamStore) Flush() error {
+	if !st.dirty {
+		return nil
+	}
+
+	stamp(&st.h)
+	err := writeAtomic(st.idx.Location, st.idx.Mode.Perm(), !st.backedUp, func(w io.Writer) error {
+		sw, err := newStreamWriter(w, st.idx.Type, st.idx.Compression, st.h)
+		if err != nil {
+			return err
+		}
+		if err = st.Iterate(sw.write); err != nil {
+			return err
+		}
+		return sw.close()
+	})
+	if err != nil {
+		return err
+	}
+
+	st.pending, st.dirty, st.backedUp = types.AllStories{}, false, true
+	return nil
+}
+
+func (st *streamStore) wasBackedUp() bool { return st.backedUp }
+
+func (st *streamStore) setBackedUp() { st.backedUp = true }
+
+func (st *streamStore) Close() error {
+	return st.Flush()
+}
@@ -0,0 +1,224 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// Severities of the problems found by Verify. Only errors make the index
+// unhealthy.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// knownMissing lists the stories that were never published, so their absence
+// is not a gap in the index.
+var knownMissing = map[int]bool{404: true}
+
+// firstStoryDate is the publication date of the first xkcd story.
+var firstStoryDate = time.Date(2005, time.September, 1, 0, 0, 0, 0, time.UTC)
+
+// Problem is a single finding of Verify.
+type Problem struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	// Num is the story the problem concerns, 0 for the index as a whole.
+	Num     int    `json:"num,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report is the outcome of Verify, meant to be consumed by tools as JSON.
+type Report struct {
+	Location string `json:"location"`
+	Type     string `json:"type"`
+	// Header is the header of the index as it is stored, i.e. before
+	// upgrading it to the current schema version.
+	Header   types.Header `json:"header"`
+	Stories  int          `json:"stories"`
+	Errors   int          `json:"errors"`
+	Warnings int          `json:"warnings"`
+	Problems []Problem    `json:"problems"`
+}
+
+func (r *Report) add(severity, check string, num int, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{
+		Severity: severity,
+		Check:    check,
+		Num:      num,
+		Message:  fmt.Sprintf(format, args...),
+	})
+
+	switch severity {
+	case SeverityError:
+		r.Errors++
+	case SeverityWarning:
+		r.Warnings++
+	}
+}
+
+// Healthy tells whether no errors were found.
+func (r *Report) Healthy() bool {
+	return r.Errors == 0
+}
+
+// checkDate reports dates that do not exist, or that are outside of the time
+// xkcd has been published.
+func checkDate(r *Report, key int, s *types.Story, now time.Time) {
+	d := time.Date(s.Year, time.Month(s.Month), s.Day, 0, 0, 0, 0, time.UTC)
+	if d.Year() != s.Year || int(d.Month()) != s.Month || d.Day() != s.Day {
+		r.add(SeverityError, "date", key, "invalid date %04d-%02d-%02d",
+			s.Year, s.Month, s.Day)
+		return
+	}
+	if d.Before(firstStoryDate) || d.After(now) {
+		r.add(SeverityWarning, "date", key, "date %s is outside of the publication period",
+			d.Format("2006-01-02"))
+	}
+}
+
+func checkHeader(r *Report, h types.Header) {
+	r.Header = h
+
+	switch {
+	case h.SchemaVersion > schemaVersion:
+		r.add(SeverityError, "schema", 0, "schema version %d, newer than the supported %d",
+			h.SchemaVersion, schemaVersion)
+	case h.SchemaVersion < schemaVersion:
+		r.add(SeverityWarning, "schema", 0, "schema version %d, older than the current %d, "+
+			"the index is upgraded by the next command modifying it", h.SchemaVersion,
+			schemaVersion)
+	}
+	if h.LastSync.IsZero() {
+		r.add(SeverityInfo, "schema", 0, "the index was never synced")
+	}
+}
+
+func checkStory(r *Report, key int, s *types.Story, now time.Time) {
+	if key != s.Num {
+		r.add(SeverityError, "key", key, "stored under key %d, but its number is %d",
+			key, s.Num)
+	}
+	if s.Num <= 0 {
+		r.add(SeverityError, "num", key, "invalid story number %d", s.Num)
+	}
+	if s.Title == "" {
+		r.add(SeverityError, "title", key, "empty title")
+	}
+	checkDate(r, key, s, now)
+
+	var last time.Time
+	for i, rev := range s.Revisions {
+		if rev.Story.Num != s.Num {
+			r.add(SeverityError, "revisions", key, "revision %d belongs to story %d",
+				i+1, rev.Story.Num)
+		}
+		if rev.Replaced.Before(last) {
+			r.add(SeverityWarning, "revisions", key, "revision %d is older than the one before it",
+				i+1)
+		}
+		last = rev.Replaced
+	}
+}
+
+// checkGaps reports missing story numbers between the lowest and the highest
+// story of the index.
+func checkGaps(r *Report, nums []int) {
+	if len(nums) == 0 {
+		return
+	}
+
+	present := map[int]bool{}
+	for _, n := range nums {
+		present[n] = true
+	}
+	for n := nums[0]; n < nums[len(nums)-1]; n++ {
+		switch {
+		case present[n]:
+		case knownMissing[n]:
+			r.add(SeverityInfo, "gaps", n, "story %d was never published", n)
+		default:
+			r.add(SeverityWarning, "gaps", n, "story %d is missing", n)
+		}
+	}
+}
+
+// checkImages reports stories sharing the same image.
+func checkImages(r *Report, a types.AllStories) {
+	first := map[string]int{}
+
+	for _, k := range a.Nums() {
+		img := a[k].Img
+		if img == "" {
+			continue
+		}
+		if n, ok := first[img]; ok {
+			r.add(SeverityWarning, "images", k, "same image as story %d: %s", n, img)
+			continue
+		}
+		first[img] = k
+	}
+}
+
+// Verify checks the integrity of the index: that the stories are stored under
+// their numbers, that their dates and titles are valid, and that there are no
+// unexpected gaps in the numbering or stories sharing an image. An index that
+// can not be read at all is reported as an error in the report, the returned
+// error is reserved for failing to produce the report.
+func (x *Index) Verify(ctx context.Context) (*Report, error) {
+	var err error
+
+	idx := x.File
+	if idx, err = resolveType(idx, x.Logger); err != nil {
+		return nil, err
+	}
+
+	r := &Report{Location: idx.Location, Type: idx.Type.String(), Problems: []Problem{}}
+
+	if _, err = os.Stat(idx.Location); err != nil {
+		r.add(SeverityError, "readable", 0, "%s", err)
+		return r, nil
+	}
+	st, err := open(idx, x.Logger, readRaw)
+	if err != nil {
+		r.add(SeverityError, "readable", 0, "%s", err)
+		return r, nil
+	}
+	defer st.Close()
+
+	checkHeader(r, st.Header())
+
+	a, keys, now := types.AllStories{}, []int{}, time.Now()
+	visit := func(key int, s *types.Story) error {
+		checkStory(r, key, s, now)
+		a[s.Num] = s
+		keys = append(keys, key)
+		return nil
+	}
+	if ki, ok := st.(keyIterator); ok {
+		err = ki.IterateKeys(visit)
+	} else {
+		err = st.Iterate(func(s *types.Story) error { return visit(s.Num, s) })
+	}
+	if err != nil {
+		r.add(SeverityError, "readable", 0, "%s", err)
+		return r, nil
+	}
+	r.Stories = len(keys)
+
+	sort.Ints(keys)
+	checkGaps(r, keys)
+	checkImages(r, a)
+
+	sort.SliceStable(r.Problems, func(i, j int) bool {
+		return r.Problems[i].Num < r.Problems[j].Num
+	})
+
+	return r, nil
+}
@@ -0,0 +1,148 @@
+// Package match implements the different ways of matching a query against a
+// story title.
+package match
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// Matcher checks whether a text matches and returns the similarity score of
+// the match, between 0 and 1.
+type Matcher func(text string) (float64, bool)
+
+// New returns a matcher for the query using the given mode.
+func New(query string, p types.MatchParams) (Matcher, error) {
+	lq := strings.ToLower(query)
+
+	switch p.Mode {
+	case types.Exact:
+		return func(text string) (float64, bool) {
+			return 1, strings.ToLower(text) == lq
+		}, nil
+	case types.Substring:
+		return func(text string) (float64, bool) {
+			return 1, strings.Contains(strings.ToLower(text), lq)
+		}, nil
+	case types.Regex:
+		re, err := regexp.Compile(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %s", err)
+		}
+		return func(text string) (float64, bool) {
+			return 1, re.MatchString(text)
+		}, nil
+	case types.Fuzzy:
+		return func(text string) (float64, bool) {
+			score := Similarity(lq, strings.ToLower(text))
+			return score, score >= p.Threshold
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported match mode `%s`", p.Mode)
+	}
+}
+
+// Similarity returns how similar the query is to the text, between 0 and 1.
+// It is the best of the trigram similarity of the two, and the edit distance
+// similarity between the query and any run of words in the text that is as
+// long as the query, so that a misspelled part of a title still matches well.
+func Similarity(query, text string) float64 {
+	res := trigramSimilarity(query, text)
+
+	qw, tw := strings.Fields(query), strings.Fields(text)
+	if len(qw) == 0 {
+		return res
+	}
+	for i := 0; i+len(qw) <= len(tw); i++ {
+		s := editSimilarity(strings.Join(qw, " "), strings.Join(tw[i:i+len(qw)], " "))
+		if s > res {
+			res = s
+		}
+	}
+	if s := editSimilarity(query, text); s > res {
+		res = s
+	}
+
+	return res
+}
+
+// editSimilarity turns the Levenshtein distance between two strings into a
+// similarity score.
+func editSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+
+	l := len(ra)
+	if len(rb) > l {
+		l = len(rb)
+	}
+	if l == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(l)
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func trigrams(s string) map[string]bool {
+	res := map[string]bool{}
+
+	r := []rune("  " + s + " ")
+	for i := 0; i+3 <= len(r); i++ {
+		res[string(r[i:i+3])] = true
+	}
+
+	return res
+}
+
+// trigramSimilarity returns the Jaccard index of the trigram sets of the two
+// strings.
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+
+	common := 0
+	for t := range ta {
+		if tb[t] {
+			common++
+		}
+	}
+	total := len(ta) + len(tb) - common
+	if total == 0 {
+		return 1
+	}
+
+	return float64(common) / float64(total)
+}
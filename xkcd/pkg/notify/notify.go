@@ -0,0 +1,169 @@
+// Package notify delivers notifications about new and edited stories.
+//
+// Sinks are given by specifications of the form:
+//
+//	stdout          a line per change on the standard output
+//	webhook:URL     a JSON event POSTed to the URL
+//	exec:COMMAND    a shell command run with the JSON event on its standard
+//	                input and XKCD_EVENT, XKCD_NUM and XKCD_TITLE set
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// Event is the notification about a single change.
+type Event struct {
+	// Type is either "new" or "edited".
+	Type  string            `json:"type"`
+	Time  time.Time         `json:"time"`
+	Story *types.Story      `json:"story"`
+	Diffs []types.FieldDiff `json:"diffs,omitempty"`
+}
+
+// NewEvent creates the notification about the change.
+func NewEvent(c types.Change) Event {
+	res := Event{Type: "edited", Time: time.Now(), Story: c.Story, Diffs: c.Diffs}
+	if c.IsNew() {
+		res.Type = "new"
+	}
+
+	return res
+}
+
+// Sink delivers notifications somewhere.
+type Sink interface {
+	Notify(ev Event) error
+}
+
+// New creates the sink described by the specification.
+func New(spec string) (Sink, error) {
+	kind, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+
+	switch strings.ToLower(kind) {
+	case "stdout":
+		return stdoutSink{}, nil
+	case "webhook":
+		if arg == "" {
+			return nil, fmt.Errorf("webhook sink requires an URL")
+		}
+		return webhookSink{url: arg, client: &http.Client{Timeout: webhookTimeout}}, nil
+	case "exec":
+		if arg == "" {
+			return nil, fmt.Errorf("exec sink requires a command")
+		}
+		return execSink{command: arg}, nil
+	default:
+		return nil, fmt.Errorf("unrecognized notification sink `%s`", spec)
+	}
+}
+
+// NewAll creates the sinks for all the specifications.
+func NewAll(specs types.Sinks) ([]Sink, error) {
+	res := make([]Sink, 0, len(specs))
+
+	for _, spec := range specs {
+		s, err := New(spec)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+
+	return res, nil
+}
+
+// Send delivers the notifications about all the changes to all the sinks. A
+// failing sink does not stop the delivery to the other ones, the failures are
+// reported to the logger.
+func Send(sinks []Sink, changes []types.Change, log types.Logger) {
+	for _, c := range changes {
+		ev := NewEvent(c)
+		for _, s := range sinks {
+			if err := s.Notify(ev); err != nil {
+				log.Printf(types.LogNotice, "Notifying about story %d failed: %s",
+					c.Story.Num, err)
+			}
+		}
+	}
+}
+
+type stdoutSink struct{}
+
+func (stdoutSink) Notify(ev Event) error {
+	_, err := fmt.Printf("%s %s\n", ev.Time.Format(time.RFC3339),
+		types.Change{Story: ev.Story, Diffs: ev.Diffs})
+	return err
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (w webhookSink) Notify(ev Event) error {
+	blob, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("event JSON marshaling failed: %s", err)
+	}
+
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s failed: %s", w.url, resp.Status)
+	}
+
+	return nil
+}
+
+type execSink struct {
+	command string
+}
+
+func (e execSink) Notify(ev Event) error {
+	blob, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("event JSON marshaling failed: %s", err)
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", e.command)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", e.command)
+	}
+	cmd.Stdin = bytes.NewReader(blob)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"XKCD_EVENT="+ev.Type,
+		"XKCD_NUM="+strconv.Itoa(ev.Story.Num),
+		"XKCD_TITLE="+ev.Story.Title,
+	)
+
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("command `%s` failed: %s", e.command, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,268 @@
+// Package output sorts and renders stories, and the statistics of their
+// characters, in the formats supported by the xkcd app.
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// record is what every story is rendered as in the JSON and template formats.
+type record struct {
+	*types.Story
+	Score float64 `json:"score,omitempty"`
+}
+
+func storyDate(s *types.Story) int {
+	return s.Year*10000 + s.Month*100 + s.Day
+}
+
+// Sort orders the hits by the given key. The default order is ascending for
+// story numbers and dates, and descending for relevance. Ties are always
+// broken by ascending story number.
+func Sort(h types.Hits, p types.SortParams) {
+	desc := p.Key == types.ByRelevance
+	switch p.Order {
+	case types.Ascending:
+		desc = false
+	case types.Descending:
+		desc = true
+	}
+
+	key := func(i int) float64 {
+		switch p.Key {
+		case types.ByDate:
+			return float64(storyDate(h[i].Story))
+		case types.ByRelevance:
+			return h[i].Score
+		default:
+			return float64(h[i].Story.Num)
+		}
+	}
+
+	sort.SliceStable(h, func(i, j int) bool {
+		ki, kj := key(i), key(j)
+		if ki == kj {
+			return h[i].Story.Num < h[j].Story.Num
+		}
+		if desc {
+			return ki > kj
+		}
+		return ki < kj
+	})
+}
+
+// Write renders the hits in the given format.
+func Write(w io.Writer, h types.Hits, p types.OutputParams) error {
+	switch p.Format {
+	case types.Human:
+		_, err := fmt.Fprint(w, h)
+		return err
+	case types.Table:
+		return writeTable(w, h)
+	case types.JSONOutput:
+		return writeJSON(w, h)
+	case types.JSONLines:
+		return writeJSONLines(w, h)
+	case types.CSV:
+		return writeCSV(w, h)
+	case types.Template:
+		return writeTemplate(w, h, p.Template)
+	default:
+		return fmt.Errorf("unsupported output format `%s`", p.Format)
+	}
+}
+
+func writeTable(w io.Writer, h types.Hits) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	for _, v := range h {
+		s := v.Story
+		line := fmt.Sprintf("%d\t%04d-%02d-%02d\t%s", s.Num, s.Year, s.Month, s.Day, s.Title)
+		if v.Score != 0 {
+			line += fmt.Sprintf("\t%.3f", v.Score)
+		}
+		if _, err := fmt.Fprintln(tw, line); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, h types.Hits) error {
+	res := make([]record, 0, len(h))
+
+	for _, v := range h {
+		res = append(res, record{Story: v.Story, Score: v.Score})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
+
+func writeJSONLines(w io.Writer, h types.Hits) error {
+	enc := json.NewEncoder(w)
+
+	for _, v := range h {
+		if err := enc.Encode(record{Story: v.Story, Score: v.Score}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeCSV(w io.Writer, h types.Hits) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{"num", "year", "month", "day", "title", "safe_title",
+		"alt", "img", "link", "news", "transcript", "score"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, v := range h {
+		s := v.Story
+		row := []string{
+			strconv.Itoa(s.Num),
+			strconv.Itoa(s.Year),
+			strconv.Itoa(s.Month),
+			strconv.Itoa(s.Day),
+			s.Title,
+			s.SafeTitle,
+			s.Alt,
+			s.Img,
+			s.Link,
+			s.News,
+			s.Transcript,
+			strconv.FormatFloat(v.Score, 'f', -1, 64),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTemplate executes the template once for every story, followed by a
+// newline. The template has access to all the types.Story fields and the
+// relevance Score.
+func writeTemplate(w io.Writer, h types.Hits, text string) error {
+	if text == "" {
+		return fmt.Errorf("template output requires a template")
+	}
+
+	t, err := template.New("story").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid template: %s", err)
+	}
+
+	for _, v := range h {
+		if err := t.Execute(w, record{Story: v.Story, Score: v.Score}); err != nil {
+			return fmt.Errorf("executing template failed: %s", err)
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteCharacters renders the statistics of the characters in the given
+// format. The template format has access to all the types.Character fields.
+func WriteCharacters(w io.Writer, cs []types.Character, p types.OutputParams) error {
+	switch p.Format {
+	case types.Human:
+		for _, c := range cs {
+			if _, err := fmt.Fprintln(w, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	case types.Table:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		for _, c := range cs {
+			if _, err := fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", c.Name, c.Lines,
+				c.Stories, c.First, c.Last); err != nil {
+				return err
+			}
+		}
+		return tw.Flush()
+	case types.JSONOutput:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(cs)
+	case types.JSONLines:
+		enc := json.NewEncoder(w)
+		for _, c := range cs {
+			if err := enc.Encode(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	case types.CSV:
+		return writeCharactersCSV(w, cs)
+	case types.Template:
+		return writeCharactersTemplate(w, cs, p.Template)
+	default:
+		return fmt.Errorf("unsupported output format `%s`", p.Format)
+	}
+}
+
+func writeCharactersCSV(w io.Writer, cs []types.Character) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"name", "lines", "stories", "first", "last"}); err != nil {
+		return err
+	}
+	for _, c := range cs {
+		row := []string{
+			c.Name,
+			strconv.Itoa(c.Lines),
+			strconv.Itoa(c.Stories),
+			strconv.Itoa(c.First),
+			strconv.Itoa(c.Last),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeCharactersTemplate(w io.Writer, cs []types.Character, text string) error {
+	if text == "" {
+		return fmt.Errorf("template output requires a template")
+	}
+
+	t, err := template.New("character").Parse(text)
+	if err != nil {
+		return fmt.Errorf("invalid template: %s", err)
+	}
+
+	for _, c := range cs {
+		if err := t.Execute(w, c); err != nil {
+			return fmt.Errorf("executing template failed: %s", err)
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
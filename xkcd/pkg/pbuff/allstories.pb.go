@@ -11,6 +11,12 @@ It is generated from these files:
 It has these top-level messages:
 	PBStory
 	PBAllStories
+	PBImage
+	PBRevision
+	PBHeader
+	PBScript
+	PBPanel
+	PBUtterance
 */
 package pbuff
 
@@ -30,17 +36,20 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type PBStory struct {
-	Alt        string `protobuf:"bytes,1,opt,name=Alt" json:"Alt,omitempty"`
-	Day        int32  `protobuf:"varint,2,opt,name=Day" json:"Day,omitempty"`
-	Img        string `protobuf:"bytes,3,opt,name=Img" json:"Img,omitempty"`
-	Link       string `protobuf:"bytes,4,opt,name=Link" json:"Link,omitempty"`
-	Month      int32  `protobuf:"varint,5,opt,name=Month" json:"Month,omitempty"`
-	News       string `protobuf:"bytes,6,opt,name=News" json:"News,omitempty"`
-	Num        int64  `protobuf:"varint,7,opt,name=Num" json:"Num,omitempty"`
-	SafeTitle  string `protobuf:"bytes,8,opt,name=SafeTitle" json:"SafeTitle,omitempty"`
-	Title      string `protobuf:"bytes,9,opt,name=Title" json:"Title,omitempty"`
-	Transcript string `protobuf:"bytes,10,opt,name=Transcript" json:"Transcript,omitempty"`
-	Year       int32  `protobuf:"varint,11,opt,name=Year" json:"Year,omitempty"`
+	Alt        string        `protobuf:"bytes,1,opt,name=Alt" json:"Alt,omitempty"`
+	Day        int32         `protobuf:"varint,2,opt,name=Day" json:"Day,omitempty"`
+	Img        string        `protobuf:"bytes,3,opt,name=Img" json:"Img,omitempty"`
+	Link       string        `protobuf:"bytes,4,opt,name=Link" json:"Link,omitempty"`
+	Month      int32         `protobuf:"varint,5,opt,name=Month" json:"Month,omitempty"`
+	News       string        `protobuf:"bytes,6,opt,name=News" json:"News,omitempty"`
+	Num        int64         `protobuf:"varint,7,opt,name=Num" json:"Num,omitempty"`
+	SafeTitle  string        `protobuf:"bytes,8,opt,name=SafeTitle" json:"SafeTitle,omitempty"`
+	Title      string        `protobuf:"bytes,9,opt,name=Title" json:"Title,omitempty"`
+	Transcript string        `protobuf:"bytes,10,opt,name=Transcript" json:"Transcript,omitempty"`
+	Year       int32         `protobuf:"varint,11,opt,name=Year" json:"Year,omitempty"`
+	LocalImage *PBImage      `protobuf:"bytes,12,opt,name=LocalImage" json:"LocalImage,omitempty"`
+	Revisions  []*PBRevision `protobuf:"bytes,13,rep,name=Revisions" json:"Revisions,omitempty"`
+	Script     *PBScript     `protobuf:"bytes,14,opt,name=Script" json:"Script,omitempty"`
 }
 
 func (m *PBStory) Reset()                    { *m = PBStory{} }
@@ -125,8 +134,32 @@ func (m *PBStory) GetYear() int32 {
 	return 0
 }
 
+func (m *PBStory) GetLocalImage() *PBImage {
+	if m != nil {
+		return m.LocalImage
+	}
+	return nil
+}
+
+func (m *PBStory) GetRevisions() []*PBRevision {
+	if m != nil {
+		return m.Revisions
+	}
+	return nil
+}
+
+func (m *PBStory) GetScript() *PBScript {
+	if m != nil {
+		return m.Script
+	}
+	return nil
+}
+
+// PBAllStories is the whole protobuf index. Indexes written before the header
+// was introduced lack it, and are at schema version 0.
 type PBAllStories struct {
-	Data map[int64]*PBStory `protobuf:"bytes,1,rep,name=Data" json:"Data,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Data   map[int64]*PBStory `protobuf:"bytes,1,rep,name=Data" json:"Data,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Header *PBHeader          `protobuf:"bytes,2,opt,name=Header" json:"Header,omitempty"`
 }
 
 func (m *PBAllStories) Reset()                    { *m = PBAllStories{} }
@@ -141,32 +174,287 @@ func (m *PBAllStories) GetData() map[int64]*PBStory {
 	return nil
 }
 
+func (m *PBAllStories) GetHeader() *PBHeader {
+	if m != nil {
+		return m.Header
+	}
+	return nil
+}
+
+type PBImage struct {
+	Path   string `protobuf:"bytes,1,opt,name=Path" json:"Path,omitempty"`
+	Size   int64  `protobuf:"varint,2,opt,name=Size" json:"Size,omitempty"`
+	Width  int32  `protobuf:"varint,3,opt,name=Width" json:"Width,omitempty"`
+	Height int32  `protobuf:"varint,4,opt,name=Height" json:"Height,omitempty"`
+	SHA256 string `protobuf:"bytes,5,opt,name=SHA256" json:"SHA256,omitempty"`
+}
+
+func (m *PBImage) Reset()                    { *m = PBImage{} }
+func (m *PBImage) String() string            { return proto.CompactTextString(m) }
+func (*PBImage) ProtoMessage()               {}
+func (*PBImage) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *PBImage) GetPath() string {
+	if m != nil {
+		return m.Path
+	}
+	return ""
+}
+
+func (m *PBImage) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *PBImage) GetWidth() int32 {
+	if m != nil {
+		return m.Width
+	}
+	return 0
+}
+
+func (m *PBImage) GetHeight() int32 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *PBImage) GetSHA256() string {
+	if m != nil {
+		return m.SHA256
+	}
+	return ""
+}
+
+type PBRevision struct {
+	Replaced int64    `protobuf:"varint,1,opt,name=Replaced" json:"Replaced,omitempty"`
+	Story    *PBStory `protobuf:"bytes,2,opt,name=Story" json:"Story,omitempty"`
+}
+
+func (m *PBRevision) Reset()                    { *m = PBRevision{} }
+func (m *PBRevision) String() string            { return proto.CompactTextString(m) }
+func (*PBRevision) ProtoMessage()               {}
+func (*PBRevision) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *PBRevision) GetReplaced() int64 {
+	if m != nil {
+		return m.Replaced
+	}
+	return 0
+}
+
+func (m *PBRevision) GetStory() *PBStory {
+	if m != nil {
+		return m.Story
+	}
+	return nil
+}
+
+// PBHeader describes the index. Times are in nanoseconds since the Unix epoch,
+// 0 if unknown.
+type PBHeader struct {
+	SchemaVersion int64  `protobuf:"varint,1,opt,name=SchemaVersion" json:"SchemaVersion,omitempty"`
+	ToolVersion   string `protobuf:"bytes,2,opt,name=ToolVersion" json:"ToolVersion,omitempty"`
+	XkcdURI       string `protobuf:"bytes,3,opt,name=XkcdURI" json:"XkcdURI,omitempty"`
+	Created       int64  `protobuf:"varint,4,opt,name=Created" json:"Created,omitempty"`
+	Updated       int64  `protobuf:"varint,5,opt,name=Updated" json:"Updated,omitempty"`
+	LastSync      int64  `protobuf:"varint,6,opt,name=LastSync" json:"LastSync,omitempty"`
+}
+
+func (m *PBHeader) Reset()                    { *m = PBHeader{} }
+func (m *PBHeader) String() string            { return proto.CompactTextString(m) }
+func (*PBHeader) ProtoMessage()               {}
+func (*PBHeader) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *PBHeader) GetSchemaVersion() int64 {
+	if m != nil {
+		return m.SchemaVersion
+	}
+	return 0
+}
+
+func (m *PBHeader) GetToolVersion() string {
+	if m != nil {
+		return m.ToolVersion
+	}
+	return ""
+}
+
+func (m *PBHeader) GetXkcdURI() string {
+	if m != nil {
+		return m.XkcdURI
+	}
+	return ""
+}
+
+func (m *PBHeader) GetCreated() int64 {
+	if m != nil {
+		return m.Created
+	}
+	return 0
+}
+
+func (m *PBHeader) GetUpdated() int64 {
+	if m != nil {
+		return m.Updated
+	}
+	return 0
+}
+
+func (m *PBHeader) GetLastSync() int64 {
+	if m != nil {
+		return m.LastSync
+	}
+	return 0
+}
+
+// PBScript is the parsed transcript of a story.
+type PBScript struct {
+	Panels      []*PBPanel `protobuf:"bytes,1,rep,name=Panels" json:"Panels,omitempty"`
+	Annotations []string   `protobuf:"bytes,2,rep,name=Annotations" json:"Annotations,omitempty"`
+}
+
+func (m *PBScript) Reset()                    { *m = PBScript{} }
+func (m *PBScript) String() string            { return proto.CompactTextString(m) }
+func (*PBScript) ProtoMessage()               {}
+func (*PBScript) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *PBScript) GetPanels() []*PBPanel {
+	if m != nil {
+		return m.Panels
+	}
+	return nil
+}
+
+func (m *PBScript) GetAnnotations() []string {
+	if m != nil {
+		return m.Annotations
+	}
+	return nil
+}
+
+type PBPanel struct {
+	Scenes   []string       `protobuf:"bytes,1,rep,name=Scenes" json:"Scenes,omitempty"`
+	Lines    []*PBUtterance `protobuf:"bytes,2,rep,name=Lines" json:"Lines,omitempty"`
+	Sounds   []string       `protobuf:"bytes,3,rep,name=Sounds" json:"Sounds,omitempty"`
+	Captions []string       `protobuf:"bytes,4,rep,name=Captions" json:"Captions,omitempty"`
+}
+
+func (m *PBPanel) Reset()                    { *m = PBPanel{} }
+func (m *PBPanel) String() string            { return proto.CompactTextString(m) }
+func (*PBPanel) ProtoMessage()               {}
+func (*PBPanel) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *PBPanel) GetScenes() []string {
+	if m != nil {
+		return m.Scenes
+	}
+	return nil
+}
+
+func (m *PBPanel) GetLines() []*PBUtterance {
+	if m != nil {
+		return m.Lines
+	}
+	return nil
+}
+
+func (m *PBPanel) GetSounds() []string {
+	if m != nil {
+		return m.Sounds
+	}
+	return nil
+}
+
+func (m *PBPanel) GetCaptions() []string {
+	if m != nil {
+		return m.Captions
+	}
+	return nil
+}
+
+type PBUtterance struct {
+	Speaker string `protobuf:"bytes,1,opt,name=Speaker" json:"Speaker,omitempty"`
+	Text    string `protobuf:"bytes,2,opt,name=Text" json:"Text,omitempty"`
+}
+
+func (m *PBUtterance) Reset()                    { *m = PBUtterance{} }
+func (m *PBUtterance) String() string            { return proto.CompactTextString(m) }
+func (*PBUtterance) ProtoMessage()               {}
+func (*PBUtterance) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *PBUtterance) GetSpeaker() string {
+	if m != nil {
+		return m.Speaker
+	}
+	return ""
+}
+
+func (m *PBUtterance) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*PBStory)(nil), "pbuff.PBStory")
 	proto.RegisterType((*PBAllStories)(nil), "pbuff.PBAllStories")
+	proto.RegisterType((*PBImage)(nil), "pbuff.PBImage")
+	proto.RegisterType((*PBRevision)(nil), "pbuff.PBRevision")
+	proto.RegisterType((*PBHeader)(nil), "pbuff.PBHeader")
+	proto.RegisterType((*PBScript)(nil), "pbuff.PBScript")
+	proto.RegisterType((*PBPanel)(nil), "pbuff.PBPanel")
+	proto.RegisterType((*PBUtterance)(nil), "pbuff.PBUtterance")
 }
 
 func init() { proto.RegisterFile("pkg/pbuff/allstories.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 302 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x4c, 0x91, 0x4f, 0x4b, 0xc3, 0x40,
-	0x10, 0xc5, 0xd9, 0xa6, 0x69, 0xcd, 0x54, 0x44, 0x16, 0x0f, 0x4b, 0x51, 0x09, 0xc5, 0x43, 0x4e,
-	0x29, 0xd6, 0x8b, 0x78, 0x6b, 0xa9, 0x48, 0x41, 0x4b, 0xd9, 0xf6, 0xe2, 0x71, 0x2b, 0xdb, 0x1a,
-	0xba, 0xf9, 0xc3, 0x66, 0xa3, 0xe4, 0x2b, 0xf8, 0x85, 0xbd, 0xca, 0xcc, 0x42, 0xed, 0xed, 0xcd,
-	0xef, 0xbd, 0x4c, 0x76, 0xdf, 0xc2, 0xb0, 0x3a, 0xec, 0xc7, 0xd5, 0xb6, 0xd9, 0xed, 0xc6, 0xca,
-	0x98, 0xda, 0x95, 0x36, 0xd3, 0x75, 0x5a, 0xd9, 0xd2, 0x95, 0x3c, 0x24, 0x3e, 0xfa, 0x65, 0xd0,
-	0x5f, 0xcd, 0xd6, 0xae, 0xb4, 0x2d, 0xbf, 0x84, 0x60, 0x6a, 0x9c, 0x60, 0x31, 0x4b, 0x22, 0x89,
-	0x12, 0xc9, 0x5c, 0xb5, 0xa2, 0x13, 0xb3, 0x24, 0x94, 0x28, 0x91, 0x2c, 0xf2, 0xbd, 0x08, 0x7c,
-	0x66, 0x91, 0xef, 0x39, 0x87, 0xee, 0x6b, 0x56, 0x1c, 0x44, 0x97, 0x10, 0x69, 0x7e, 0x05, 0xe1,
-	0x5b, 0x59, 0xb8, 0x4f, 0x11, 0xd2, 0x97, 0x7e, 0xc0, 0xe4, 0x52, 0x7f, 0xd7, 0xa2, 0xe7, 0x93,
-	0xa8, 0x71, 0xdf, 0xb2, 0xc9, 0x45, 0x3f, 0x66, 0x49, 0x20, 0x51, 0xf2, 0x6b, 0x88, 0xd6, 0x6a,
-	0xa7, 0x37, 0x99, 0x33, 0x5a, 0x9c, 0x51, 0xf4, 0x1f, 0xe0, 0x66, 0xef, 0x44, 0xe4, 0xf8, 0x81,
-	0xdf, 0x02, 0x6c, 0xac, 0x2a, 0xea, 0x0f, 0x9b, 0x55, 0x4e, 0x00, 0x59, 0x27, 0x04, 0xff, 0xfc,
-	0xae, 0x95, 0x15, 0x03, 0x3a, 0x0e, 0xe9, 0xd1, 0x0f, 0x83, 0xf3, 0xd5, 0x6c, 0x6a, 0xcc, 0xda,
-	0xf7, 0xc2, 0xef, 0xa1, 0x3b, 0x57, 0x4e, 0x09, 0x16, 0x07, 0xc9, 0x60, 0x72, 0x93, 0x52, 0x41,
-	0xe9, 0x69, 0x24, 0x45, 0xff, 0xb9, 0x70, 0xb6, 0x95, 0x14, 0x1d, 0xbe, 0x40, 0x74, 0x44, 0x78,
-	0x95, 0x83, 0x6e, 0xa9, 0xbe, 0x40, 0xa2, 0xe4, 0x77, 0x10, 0x7e, 0x29, 0xd3, 0x68, 0x2a, 0x70,
-	0x30, 0xb9, 0x38, 0xae, 0xa4, 0xbe, 0xa5, 0x37, 0x9f, 0x3a, 0x8f, 0x6c, 0xdb, 0xa3, 0x47, 0x79,
-	0xf8, 0x0b, 0x00, 0x00, 0xff, 0xff, 0x36, 0xd9, 0x60, 0xe2, 0xb2, 0x01, 0x00, 0x00,
+	// 664 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x54, 0x4f, 0x6b, 0xdb, 0x4a,
+	0x10, 0x47, 0x96, 0xe5, 0x44, 0xe3, 0x24, 0xef, 0xbd, 0xe5, 0xf1, 0x58, 0xc2, 0x6b, 0x11, 0x22,
+	0xb4, 0x3e, 0x39, 0xd4, 0xa5, 0xa5, 0xb4, 0x27, 0x27, 0x29, 0x8d, 0xc1, 0x0d, 0x66, 0xe5, 0xf4,
+	0xcf, 0x71, 0x23, 0x6d, 0x6c, 0x61, 0x59, 0x12, 0xd2, 0x3a, 0xa9, 0x7b, 0x29, 0xfd, 0x4c, 0xbd,
+	0xf6, 0xc3, 0x95, 0x99, 0x5d, 0xcb, 0x6e, 0xa0, 0x27, 0xcf, 0xef, 0xcf, 0xce, 0xce, 0xcc, 0x8e,
+	0x0c, 0xc7, 0xe5, 0x62, 0x76, 0x5a, 0xde, 0xac, 0x6e, 0x6f, 0x4f, 0x65, 0x96, 0xd5, 0xba, 0xa8,
+	0x52, 0x55, 0xf7, 0xcb, 0xaa, 0xd0, 0x05, 0xf3, 0x88, 0x0f, 0xbf, 0xbb, 0xb0, 0x37, 0x39, 0x8b,
+	0x74, 0x51, 0xad, 0xd9, 0xdf, 0xe0, 0x0e, 0x33, 0xcd, 0x9d, 0xc0, 0xe9, 0xf9, 0x02, 0x43, 0x64,
+	0x2e, 0xe4, 0x9a, 0xb7, 0x02, 0xa7, 0xe7, 0x09, 0x0c, 0x91, 0x19, 0x2d, 0x67, 0xdc, 0x35, 0x9e,
+	0xd1, 0x72, 0xc6, 0x18, 0xb4, 0xc7, 0x69, 0xbe, 0xe0, 0x6d, 0xa2, 0x28, 0x66, 0xff, 0x82, 0xf7,
+	0xbe, 0xc8, 0xf5, 0x9c, 0x7b, 0x74, 0xd2, 0x00, 0x74, 0x5e, 0xa9, 0xfb, 0x9a, 0x77, 0x8c, 0x13,
+	0x63, 0xcc, 0x77, 0xb5, 0x5a, 0xf2, 0xbd, 0xc0, 0xe9, 0xb9, 0x02, 0x43, 0xf6, 0x3f, 0xf8, 0x91,
+	0xbc, 0x55, 0xd3, 0x54, 0x67, 0x8a, 0xef, 0x93, 0x75, 0x4b, 0x60, 0x66, 0xa3, 0xf8, 0xa4, 0x18,
+	0xc0, 0x1e, 0x03, 0x4c, 0x2b, 0x99, 0xd7, 0x71, 0x95, 0x96, 0x9a, 0x03, 0x49, 0x3b, 0x0c, 0xde,
+	0xfc, 0x59, 0xc9, 0x8a, 0x77, 0xa9, 0x1c, 0x8a, 0x59, 0x1f, 0x60, 0x5c, 0xc4, 0x32, 0x1b, 0x2d,
+	0xe5, 0x4c, 0xf1, 0x83, 0xc0, 0xe9, 0x75, 0x07, 0x47, 0x7d, 0x9a, 0x4a, 0x7f, 0x72, 0x46, 0xac,
+	0xd8, 0x71, 0xb0, 0x53, 0xf0, 0x85, 0xba, 0x4b, 0xeb, 0xb4, 0xc8, 0x6b, 0x7e, 0x18, 0xb8, 0xbd,
+	0xee, 0xe0, 0x9f, 0xc6, 0xbe, 0x51, 0xc4, 0xd6, 0xc3, 0x9e, 0x42, 0x27, 0x32, 0x05, 0x1d, 0x51,
+	0xf2, 0xbf, 0x1a, 0xb7, 0xa1, 0x85, 0x95, 0xc3, 0x1f, 0x0e, 0x1c, 0x4c, 0xce, 0x86, 0x59, 0x16,
+	0x99, 0x17, 0x62, 0xcf, 0xa0, 0x7d, 0x21, 0xb5, 0xe4, 0x0e, 0xdd, 0xf2, 0xa8, 0x39, 0xb7, 0xb5,
+	0xf4, 0x51, 0x7f, 0x9b, 0xeb, 0x6a, 0x2d, 0xc8, 0x8a, 0x97, 0x5d, 0x2a, 0x99, 0xa8, 0x8a, 0xb7,
+	0x1e, 0x5c, 0x66, 0x68, 0x61, 0xe5, 0xe3, 0x77, 0xe0, 0x37, 0x67, 0x71, 0xfa, 0x0b, 0xb5, 0xa6,
+	0x17, 0x77, 0x05, 0x86, 0xec, 0x04, 0xbc, 0x3b, 0x99, 0xad, 0x14, 0x6f, 0x3d, 0x18, 0x08, 0xad,
+	0x88, 0x30, 0xe2, 0xeb, 0xd6, 0x2b, 0x27, 0xbc, 0xc7, 0xc5, 0x31, 0xa3, 0x61, 0xd0, 0x9e, 0x48,
+	0x3d, 0xb7, 0x9b, 0x43, 0x31, 0x72, 0x51, 0xfa, 0xd5, 0xe4, 0x71, 0x05, 0xc5, 0xf8, 0x78, 0x1f,
+	0xd3, 0x44, 0xcf, 0x69, 0x7d, 0x3c, 0x61, 0x00, 0xfb, 0x0f, 0x4b, 0x4f, 0x67, 0x73, 0x4d, 0x2b,
+	0xe4, 0x09, 0x8b, 0x90, 0x8f, 0x2e, 0x87, 0x83, 0x17, 0x2f, 0x69, 0x8b, 0x7c, 0x61, 0x51, 0x78,
+	0x05, 0xb0, 0x1d, 0x38, 0x3b, 0x86, 0x7d, 0xa1, 0xca, 0x4c, 0xc6, 0x2a, 0xb1, 0x7d, 0x34, 0x18,
+	0x9b, 0xa1, 0xb2, 0xff, 0xd4, 0x0c, 0xfd, 0x84, 0x3f, 0x1d, 0xd8, 0xdf, 0x8c, 0x89, 0x9d, 0xc0,
+	0x61, 0x14, 0xcf, 0xd5, 0x52, 0x7e, 0x50, 0x15, 0xe6, 0xb7, 0x39, 0x7f, 0x27, 0x59, 0x00, 0xdd,
+	0x69, 0x51, 0x64, 0x1b, 0x4f, 0x8b, 0xea, 0xdb, 0xa5, 0x18, 0x87, 0xbd, 0x4f, 0x8b, 0x38, 0xb9,
+	0x16, 0x23, 0xfb, 0xad, 0x6c, 0x20, 0x2a, 0xe7, 0x95, 0x92, 0x5a, 0x25, 0xd4, 0xaf, 0x2b, 0x36,
+	0x10, 0x95, 0xeb, 0x32, 0x21, 0xc5, 0x33, 0x8a, 0x85, 0xd8, 0xe4, 0x58, 0xd6, 0x3a, 0x5a, 0xe7,
+	0x31, 0x7d, 0x3d, 0xae, 0x68, 0x70, 0x38, 0xc5, 0xea, 0xcd, 0x26, 0xb1, 0x27, 0xd0, 0x99, 0xc8,
+	0x5c, 0x65, 0xb5, 0x5d, 0x9d, 0x6d, 0xc7, 0x44, 0x0b, 0xab, 0x62, 0xfd, 0xc3, 0x3c, 0x2f, 0xb4,
+	0xd4, 0xb4, 0xcd, 0xad, 0xc0, 0xc5, 0xfa, 0x77, 0xa8, 0xf0, 0x1b, 0xbe, 0x2e, 0xb9, 0xe9, 0x1d,
+	0x62, 0x95, 0x2b, 0x93, 0xd4, 0x17, 0x16, 0xb1, 0x1e, 0x78, 0xe3, 0x34, 0x57, 0xe6, 0x78, 0x77,
+	0xc0, 0x9a, 0xbb, 0xae, 0xb5, 0x56, 0x95, 0xcc, 0x63, 0x25, 0x8c, 0x81, 0x32, 0x14, 0xab, 0x3c,
+	0xa9, 0xb9, 0x6b, 0x33, 0x10, 0xc2, 0xb6, 0xce, 0x65, 0x69, 0x6a, 0x68, 0x93, 0xd2, 0xe0, 0xf0,
+	0x0d, 0x74, 0x77, 0x32, 0xe1, 0x6c, 0xa2, 0x52, 0xc9, 0x85, 0xaa, 0xec, 0x96, 0x6d, 0x20, 0x2e,
+	0xda, 0x54, 0x7d, 0xd1, 0xf6, 0x11, 0x28, 0xbe, 0xe9, 0xd0, 0x7f, 0xdc, 0xf3, 0x5f, 0x03, 0x00,
+	0x0c, 0x79, 0x90, 0x85, 0x01, 0x05, 0x00, 0x00,
 }
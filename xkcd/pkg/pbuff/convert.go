@@ -1,6 +1,151 @@
 package pbuff
 
-import "github.com/vespian/go-exercises/xkcd/pkg/types"
+import (
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+func PBImageFromImage(i *types.Image) *PBImage {
+	if i == nil {
+		return nil
+	}
+
+	return &PBImage{
+		Path:   i.Path,
+		Size:   i.Size,
+		Width:  int32(i.Width),
+		Height: int32(i.Height),
+		SHA256: i.SHA256,
+	}
+}
+
+func ImageFromPBImage(p *PBImage) *types.Image {
+	if p == nil {
+		return nil
+	}
+
+	return &types.Image{
+		Path:   p.Path,
+		Size:   p.Size,
+		Width:  int(p.Width),
+		Height: int(p.Height),
+		SHA256: p.SHA256,
+	}
+}
+
+// nanosFromTime converts the time to nanoseconds since the Unix epoch, with
+// the zero time mapped to 0.
+func nanosFromTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func timeFromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+func PBHeaderFromHeader(h types.Header) *PBHeader {
+	return &PBHeader{
+		SchemaVersion: int64(h.SchemaVersion),
+		ToolVersion:   h.ToolVersion,
+		XkcdURI:       h.XkcdURI,
+		Created:       nanosFromTime(h.Created),
+		Updated:       nanosFromTime(h.Updated),
+		LastSync:      nanosFromTime(h.LastSync),
+	}
+}
+
+// HeaderFromPBHeader converts the header, a missing one meaning schema
+// version 0.
+func HeaderFromPBHeader(p *PBHeader) types.Header {
+	if p == nil {
+		return types.Header{}
+	}
+
+	return types.Header{
+		SchemaVersion: int(p.SchemaVersion),
+		ToolVersion:   p.ToolVersion,
+		XkcdURI:       p.XkcdURI,
+		Created:       timeFromNanos(p.Created),
+		Updated:       timeFromNanos(p.Updated),
+		LastSync:      timeFromNanos(p.LastSync),
+	}
+}
+
+func PBRevisionsFromRevisions(rs []types.Revision) []*PBRevision {
+	if len(rs) == 0 {
+		return nil
+	}
+
+	res := make([]*PBRevision, 0, len(rs))
+	for _, r := range rs {
+		res = append(res, &PBRevision{
+			Replaced: nanosFromTime(r.Replaced),
+			Story:    PBStoryFromStory(r.Story),
+		})
+	}
+
+	return res
+}
+
+func RevisionsFromPBRevisions(ps []*PBRevision) []types.Revision {
+	if len(ps) == 0 {
+		return nil
+	}
+
+	res := make([]types.Revision, 0, len(ps))
+	for _, p := range ps {
+		if p.Story == nil {
+			continue
+		}
+		res = append(res, types.Revision{
+			Replaced: timeFromNanos(p.Replaced),
+			Story:    StoryFromPBStory(p.Story),
+		})
+	}
+
+	return res
+}
+
+func PBScriptFromScript(s *types.Script) *PBScript {
+	if s == nil {
+		return nil
+	}
+
+	res := &PBScript{Annotations: s.Annotations}
+	for _, p := range s.Panels {
+		pp := &PBPanel{Scenes: p.Scenes, Sounds: p.Sounds, Captions: p.Captions}
+		for _, l := range p.Lines {
+			pp.Lines = append(pp.Lines, &PBUtterance{Speaker: l.Speaker, Text: l.Text})
+		}
+		res.Panels = append(res.Panels, pp)
+	}
+
+	return res
+}
+
+func ScriptFromPBScript(p *PBScript) *types.Script {
+	if p == nil {
+		return nil
+	}
+
+	res := &types.Script{Annotations: p.Annotations}
+	for _, pp := range p.Panels {
+		panel := types.Panel{Scenes: pp.Scenes, Sounds: pp.Sounds, Captions: pp.Captions}
+		for _, l := range pp.Lines {
+			panel.Lines = append(panel.Lines, types.Utterance{Speaker: l.Speaker, Text: l.Text})
+		}
+		res.Panels = append(res.Panels, panel)
+	}
+
+	return res
+}
 
 func PBStoryFromStory(s *types.Story) *PBStory {
 	res := PBStory{
@@ -15,6 +160,9 @@ func PBStoryFromStory(s *types.Story) *PBStory {
 		Title:      s.Title,
 		Transcript: s.Transcript,
 		Year:       int32(s.Year),
+		LocalImage: PBImageFromImage(s.LocalImage),
+		Revisions:  PBRevisionsFromRevisions(s.Revisions),
+		Script:     PBScriptFromScript(s.Script),
 	}
 
 	return &res
@@ -33,6 +181,9 @@ func StoryFromPBStory(p *PBStory) *types.Story {
 		Title:      p.Title,
 		Transcript: p.Transcript,
 		Year:       int(p.Year),
+		LocalImage: ImageFromPBImage(p.LocalImage),
+		Revisions:  RevisionsFromPBRevisions(p.Revisions),
+		Script:     ScriptFromPBScript(p.Script),
 	}
 
 	return &res
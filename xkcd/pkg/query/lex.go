@@ -0,0 +1,119 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	// tokWord is a bare word, possibly prefixed with a field name, like
+	// `title:barrel` or `year:>=2010`.
+	tokWord
+	// tokField is a field name followed directly by a quoted phrase, like the
+	// `title:` in `title:"petit trees"`.
+	tokField
+	tokPhrase
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokEOF:
+		return "end of query"
+	case tokLParen:
+		return "`(`"
+	case tokRParen:
+		return "`)`"
+	case tokAnd:
+		return "AND"
+	case tokOr:
+		return "OR"
+	case tokNot:
+		return "NOT"
+	case tokWord, tokField:
+		return "term"
+	case tokPhrase:
+		return "phrase"
+	default:
+		return "unknown"
+	}
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// SyntaxError describes a problem with the query, along with the position (in
+// bytes, counting from 1) at which it was found.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Msg)
+}
+
+func errorAt(pos int, format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Pos: pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isSeparator(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '(' || c == ')' || c == '"'
+}
+
+func lex(in string) ([]token, error) {
+	var res []token
+
+	for i := 0; i < len(in); {
+		c := in[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			res = append(res, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			res = append(res, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == '"':
+			end := strings.IndexByte(in[i+1:], '"')
+			if end < 0 {
+				return nil, errorAt(i, "unterminated phrase")
+			}
+			res = append(res, token{kind: tokPhrase, text: in[i+1 : i+1+end], pos: i})
+			i += end + 2
+		default:
+			start := i
+			for i < len(in) && !isSeparator(in[i]) {
+				i++
+			}
+			word := in[start:i]
+
+			switch {
+			case word == "AND" || word == "&&":
+				res = append(res, token{kind: tokAnd, text: word, pos: start})
+			case word == "OR" || word == "||":
+				res = append(res, token{kind: tokOr, text: word, pos: start})
+			case word == "NOT" || word == "!":
+				res = append(res, token{kind: tokNot, text: word, pos: start})
+			case strings.HasSuffix(word, ":") && i < len(in) && in[i] == '"':
+				res = append(res, token{kind: tokField, text: word[:len(word)-1], pos: start})
+			default:
+				res = append(res, token{kind: tokWord, text: word, pos: start})
+			}
+		}
+	}
+	res = append(res, token{kind: tokEOF, pos: len(in)})
+
+	return res, nil
+}
@@ -0,0 +1,495 @@
+// Package query implements the query language used for searching the xkcd
+// index.
+//
+// A query consists of terms combined with AND (or just whitespace), OR and
+// NOT, grouped with parentheses. A term is either a word or a quoted phrase,
+// matched against the title, safe title, alt text and transcript, or a
+// `field:value` pair restricting it to a single types.Story field. Numeric
+// fields accept comparisons (`year:>=2010`, `num:<100`) and ranges
+// (`num:100..200`), and the `date` pseudo-field does the same for
+// YYYY[-MM[-DD]] dates (`date:2010-05..2011`). The `speaker` pseudo-field
+// matches the names of the speakers of the parsed transcript
+// (`speaker:"Black Hat"`).
+package query
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/search"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// dateField is the name of the pseudo-field built from Year, Month and Day.
+const dateField = "date"
+
+// speakerField is the name of the pseudo-field matching the speakers of the
+// Script.
+const speakerField = "speaker"
+
+// field describes a types.Story field that can be used in `field:value` terms.
+type field struct {
+	index   int
+	numeric bool
+}
+
+// fields maps lowercase field names, and their JSON names, to the types.Story
+// fields.
+var fields = storyFields()
+
+// defaultFields are the fields matched by terms without a field name.
+var defaultFields = []string{"title", "safe_title", "alt", "transcript"}
+
+func storyFields() map[string]field {
+	res := map[string]field{}
+
+	t := reflect.TypeOf(types.Story{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		var fd field
+		switch f.Type.Kind() {
+		case reflect.String:
+			fd = field{index: i}
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			fd = field{index: i, numeric: true}
+		default:
+			continue
+		}
+
+		res[strings.ToLower(f.Name)] = fd
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+			res[strings.ToLower(tag)] = fd
+		}
+	}
+
+	return res
+}
+
+func (f field) text(s *types.Story) string {
+	return reflect.ValueOf(s).Elem().Field(f.index).String()
+}
+
+func (f field) number(s *types.Story) int64 {
+	return reflect.ValueOf(s).Elem().Field(f.index).Int()
+}
+
+// node is a single element of a parsed query.
+type node interface {
+	match(s *types.Story) bool
+}
+
+type matchAll struct{}
+
+func (matchAll) match(*types.Story) bool { return true }
+
+type andNode struct{ l, r node }
+
+func (n andNode) match(s *types.Story) bool { return n.l.match(s) && n.r.match(s) }
+
+type orNode struct{ l, r node }
+
+func (n orNode) match(s *types.Story) bool { return n.l.match(s) || n.r.match(s) }
+
+type notNode struct{ n node }
+
+func (n notNode) match(s *types.Story) bool { return !n.n.match(s) }
+
+// textNode matches stories where the terms appear in a row in any of the
+// fields, after tokenization.
+type textNode struct {
+	fields []field
+	terms  []string
+}
+
+func (n textNode) match(s *types.Story) bool {
+	for _, f := range n.fields {
+		if containsSeq(search.Tokenize(f.text(s)), n.terms) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsSeq(haystack, needle []string) bool {
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		j := 0
+		for j < len(needle) && haystack[i+j] == needle[j] {
+			j++
+		}
+		if j == len(needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// speakerNode matches stories where the terms appear in a row in the name of
+// any of the speakers, after tokenization.
+type speakerNode struct {
+	terms []string
+}
+
+func (n speakerNode) match(s *types.Story) bool {
+	for _, name := range s.Script.Speakers() {
+		if containsSeq(search.Tokenize(name), n.terms) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeNode matches stories where the value of a numeric field, or the date,
+// falls within [lo, hi).
+type rangeNode struct {
+	value  func(s *types.Story) int64
+	lo, hi int64
+}
+
+func (n rangeNode) match(s *types.Story) bool {
+	v := n.value(s)
+	return v >= n.lo && v < n.hi
+}
+
+func storyDate(s *types.Story) int64 {
+	return dayNumber(s.Year, s.Month, s.Day)
+}
+
+func dayNumber(y, m, d int) int64 {
+	return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// Query is a parsed query.
+type Query struct {
+	root  node
+	terms []string
+}
+
+// Match checks whether a story matches the query.
+func (q *Query) Match(s *types.Story) bool {
+	return q.root.match(s)
+}
+
+// Text returns the text of all the terms that are not negated, suitable for
+// ranking the matching stories with the full-text index.
+func (q *Query) Text() string {
+	return strings.Join(q.terms, " ")
+}
+
+// Parse parses a query. An empty query matches all the stories. Syntax
+// errors are returned as *SyntaxError.
+func Parse(in string) (*Query, error) {
+	toks, err := lex(in)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{toks: toks, q: &Query{}}
+	if p.peek().kind == tokEOF {
+		p.q.root = matchAll{}
+		return p.q, nil
+	}
+
+	root, err := p.parseOr(false)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, errorAt(t.pos, "unexpected %s", t.kind)
+	}
+	p.q.root = root
+
+	return p.q, nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+	q    *Query
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// The `neg` argument of the parse functions tracks whether the node being
+// parsed is negated, so that negated terms are not used for ranking.
+
+func (p *parser) parseOr(neg bool) (node, error) {
+	l, err := p.parseAnd(neg)
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokOr {
+		p.next()
+		r, err := p.parseAnd(neg)
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l, r}
+	}
+
+	return l, nil
+}
+
+func (p *parser) parseAnd(neg bool) (node, error) {
+	l, err := p.parseUnary(neg)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch p.peek().kind {
+		case tokEOF, tokRParen, tokOr:
+			return l, nil
+		case tokAnd:
+			p.next()
+		}
+		r, err := p.parseUnary(neg)
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l, r}
+	}
+}
+
+func (p *parser) parseUnary(neg bool) (node, error) {
+	if p.peek().kind != tokNot {
+		return p.parsePrimary(neg)
+	}
+
+	p.next()
+	n, err := p.parseUnary(!neg)
+	if err != nil {
+		return nil, err
+	}
+	return notNode{n}, nil
+}
+
+func (p *parser) parsePrimary(neg bool) (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokLParen:
+		n, err := p.parseOr(neg)
+		if err != nil {
+			return nil, err
+		}
+		if r := p.next(); r.kind != tokRParen {
+			return nil, errorAt(r.pos, "expected `)`, got %s", r.kind)
+		}
+		return n, nil
+	case tokPhrase:
+		return p.textTerm("", t.text, t.pos+1, neg)
+	case tokField:
+		v := p.next()
+		return p.fieldTerm(t.text, t.pos, v.text, v.pos+1, neg)
+	case tokWord:
+		i := strings.IndexByte(t.text, ':')
+		if i <= 0 {
+			return p.textTerm("", t.text, t.pos, neg)
+		}
+		return p.fieldTerm(t.text[:i], t.pos, t.text[i+1:], t.pos+i+1, neg)
+	default:
+		return nil, errorAt(t.pos, "unexpected %s", t.kind)
+	}
+}
+
+// textTerm parses a term matched against the given text field, or the default
+// fields if `name` is empty. Terms without any words left after tokenization,
+// like stop words or punctuation, are rejected, as they would match anything.
+func (p *parser) textTerm(name, value string, valuePos int, neg bool) (node, error) {
+	var fs []field
+
+	terms := search.Tokenize(value)
+	name = strings.ToLower(name)
+	switch {
+	case len(terms) > 0:
+	case name == "":
+		return nil, errorAt(valuePos, "no words in term `%s`", value)
+	default:
+		return nil, errorAt(valuePos, "no words in value `%s` of field `%s`", value, name)
+	}
+
+	if name == "" {
+		for _, n := range defaultFields {
+			fs = append(fs, fields[n])
+		}
+	} else {
+		fs = []field{fields[name]}
+	}
+
+	if !neg && (name == "" || contains(defaultFields, name)) {
+		p.q.terms = append(p.q.terms, value)
+	}
+
+	return textNode{fields: fs, terms: terms}, nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldTerm parses a `field:value` term, `namePos` and `valuePos` are used for
+// error reporting.
+func (p *parser) fieldTerm(name string, namePos int, value string, valuePos int,
+	neg bool,
+) (node, error) {
+	name = strings.ToLower(name)
+	if value == "" {
+		return nil, errorAt(valuePos, "missing value for field `%s`", name)
+	}
+
+	if name == dateField {
+		lo, hi, err := parseInterval(value, valuePos, parseDate)
+		if err != nil {
+			return nil, err
+		}
+		return rangeNode{value: storyDate, lo: lo, hi: hi}, nil
+	}
+	if name == speakerField {
+		terms := search.Tokenize(value)
+		if len(terms) == 0 {
+			return nil, errorAt(valuePos, "no words in value `%s` of field `%s`", value, name)
+		}
+		return speakerNode{terms: terms}, nil
+	}
+
+	f, ok := fields[name]
+	switch {
+	case !ok:
+		return nil, errorAt(namePos, "unknown field `%s`", name)
+	case !f.numeric:
+		return p.textTerm(name, value, valuePos, neg)
+	}
+
+	lo, hi, err := parseInterval(value, valuePos, parseNumber)
+	if err != nil {
+		return nil, err
+	}
+	return rangeNode{value: f.number, lo: lo, hi: hi}, nil
+}
+
+// boundParser parses a single value into the [lo, hi) interval that it
+// covers, e.g. the whole year for `2010` given as a date.
+type boundParser func(value string, pos int) (lo, hi int64, err error)
+
+func parseNumber(value string, pos int) (int64, int64, error) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, 0, errorAt(pos, "invalid number `%s`", value)
+	}
+	// The interval would be empty, as its end overflows.
+	if v == math.MaxInt64 {
+		return 0, 0, errorAt(pos, "number `%s` out of range", value)
+	}
+	return v, v + 1, nil
+}
+
+func parseDate(value string, pos int) (int64, int64, error) {
+	var parts [3]int
+
+	fs := strings.Split(value, "-")
+	if len(fs) > 3 {
+		return 0, 0, errorAt(pos, "invalid date `%s`, expected YYYY[-MM[-DD]]", value)
+	}
+	for i, f := range fs {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return 0, 0, errorAt(pos, "invalid date `%s`, expected YYYY[-MM[-DD]]", value)
+		}
+		parts[i] = v
+	}
+
+	y, m, d := parts[0], parts[1], parts[2]
+	switch {
+	case len(fs) > 1 && (m < 1 || m > 12):
+		return 0, 0, errorAt(pos, "invalid month in date `%s`", value)
+	case len(fs) > 2 && (d < 1 || !validDay(y, m, d)):
+		return 0, 0, errorAt(pos, "invalid day in date `%s`", value)
+	}
+
+	switch len(fs) {
+	case 1:
+		return dayNumber(y, 1, 1), dayNumber(y+1, 1, 1), nil
+	case 2:
+		return dayNumber(y, m, 1), dayNumber(y, m+1, 1), nil
+	default:
+		return dayNumber(y, m, d), dayNumber(y, m, d+1), nil
+	}
+}
+
+// validDay checks that the day exists in the given month, as time.Date
+// normalizes days past the end of the month into the next one.
+func validDay(y, m, d int) bool {
+	return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC).Day() == d
+}
+
+// parseInterval parses a comparison (`>=v`, `<v`, ...), a range (`a..b`, with
+// either end optional) or a single value into a [lo, hi) interval. Ranges
+// whose start is past their end are rejected.
+func parseInterval(value string, pos int, parse boundParser) (int64, int64, error) {
+	if i := strings.Index(value, ".."); i >= 0 {
+		lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+		if from := value[:i]; from != "" {
+			l, _, err := parse(from, pos)
+			if err != nil {
+				return 0, 0, err
+			}
+			lo = l
+		}
+		if to := value[i+2:]; to != "" {
+			_, h, err := parse(to, pos+i+2)
+			if err != nil {
+				return 0, 0, err
+			}
+			hi = h
+		}
+		if lo >= hi {
+			return 0, 0, errorAt(pos, "inverted range `%s`", value)
+		}
+		return lo, hi, nil
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if !strings.HasPrefix(value, op) {
+			continue
+		}
+
+		lo, hi, err := parse(value[len(op):], pos+len(op))
+		if err != nil {
+			return 0, 0, err
+		}
+		switch op {
+		case ">=":
+			return lo, math.MaxInt64, nil
+		case ">":
+			return hi, math.MaxInt64, nil
+		case "<=":
+			return math.MinInt64, hi, nil
+		case "<":
+			return math.MinInt64, lo, nil
+		default:
+			return lo, hi, nil
+		}
+	}
+
+	return parse(value, pos)
+}
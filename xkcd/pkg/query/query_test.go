@@ -0,0 +1,163 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/transcript"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// stories are matched by the queries of the tests.
+var stories = []*types.Story{
+	{Num: 1, Title: "Barrel - Part 1", Alt: "Don't we all.",
+		Transcript: "Boy: I wonder where I'll float next?", Year: 2006, Month: 1, Day: 1},
+	{Num: 2, Title: "Petit Trees (sketch)", Alt: "Le Petit Prince",
+		Year: 2006, Month: 1, Day: 1},
+	{Num: 3, Title: "Island (sketch)", Alt: "Hello, island",
+		Year: 2006, Month: 1, Day: 1},
+	{Num: 500, Title: "Election", Alt: "Black Hat voted", Year: 2008, Month: 11, Day: 5,
+		Transcript: "Black Hat: I voted for the barrel.\nCueball: Why?"},
+	{Num: 1000, Title: "1000 Comics", Alt: "Thank you",
+		Year: 2012, Month: 1, Day: 6},
+}
+
+func init() {
+	for _, s := range stories {
+		s.SafeTitle = s.Title
+		s.Script = transcript.Parse(s.Transcript)
+	}
+}
+
+func matching(q *Query) []int {
+	res := []int{}
+	for _, s := range stories {
+		if q.Match(s) {
+			res = append(res, s.Num)
+		}
+	}
+	return res
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		want  []int
+	}{
+		{"", []int{1, 2, 3, 500, 1000}},
+		{"barrel", []int{1, 500}},
+		{"BARREL", []int{1, 500}},
+		{"sketch island", []int{3}},
+		{"sketch AND island", []int{3}},
+		{"sketch && island", []int{3}},
+		{"island OR barrel", []int{1, 3, 500}},
+		{"island || barrel", []int{1, 3, 500}},
+		{"NOT sketch", []int{1, 500, 1000}},
+		{"! sketch", []int{1, 500, 1000}},
+		{"NOT NOT sketch", []int{2, 3}},
+		// AND binds tighter than OR.
+		{"island OR barrel sketch", []int{3}},
+		{"barrel sketch OR island", []int{3}},
+		{"(island OR barrel) NOT sketch", []int{1, 500}},
+		{"sketch NOT (island OR trees)", []int{}},
+		{`"petit trees"`, []int{2}},
+		{`"trees petit"`, []int{}},
+		{"title:barrel", []int{1}},
+		{`title:"petit trees"`, []int{2}},
+		{"alt:island", []int{3}},
+		{"safe_title:election", []int{500}},
+		{"year:2006", []int{1, 2, 3}},
+		{"year:>2006", []int{500, 1000}},
+		{"year:>=2008", []int{500, 1000}},
+		{"year:<2008", []int{1, 2, 3}},
+		{"year:<=2008", []int{1, 2, 3, 500}},
+		{"num:2..500", []int{2, 3, 500}},
+		{"num:..2", []int{1, 2}},
+		{"num:500..", []int{500, 1000}},
+		{"date:2008-11-05", []int{500}},
+		{"date:2008-11", []int{500}},
+		{"date:2006..2008", []int{1, 2, 3, 500}},
+		{"date:>2008-11-05", []int{1000}},
+		{"date:2012-02-29..", []int{}},
+		{`speaker:"black hat"`, []int{500}},
+		{"speaker:cueball", []int{500}},
+		{"speaker:boy", []int{1}},
+		{"speaker:hat year:2008", []int{500}},
+	} {
+		q, err := Parse(tc.query)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %s", tc.query, err)
+			continue
+		}
+		if got := matching(q); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Parse(%q) matches %v, want %v", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	for _, tc := range []struct {
+		query, want string
+	}{
+		{"barrel", "barrel"},
+		{`sketch "petit trees"`, "sketch petit trees"},
+		{"island NOT sketch", "island"},
+		{"NOT (island OR NOT barrel)", "barrel"},
+		{"title:barrel alt:island year:2006", "barrel island"},
+		{"transcript:boy speaker:boy", "boy"},
+	} {
+		q, err := Parse(tc.query)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %s", tc.query, err)
+			continue
+		}
+		if got := q.Text(); got != tc.want {
+			t.Errorf("Parse(%q).Text() = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		pos   int
+	}{
+		{`"petit trees`, 1},
+		{"(barrel", 8},
+		{"barrel)", 7},
+		{"barrel OR", 10},
+		{"NOT", 4},
+		{"AND barrel", 1},
+		{"title:", 7},
+		{"color:red", 1},
+		{"barrel colour:red", 8},
+		{"the", 1},
+		{"barrel -", 8},
+		{`"!!"`, 2},
+		{"alt:-", 5},
+		{`speaker:"!!"`, 10},
+		{"year:soon", 6},
+		{"year:>=soon", 8},
+		{"num:5..x", 8},
+		{"num:5..1", 5},
+		{"num:9223372036854775807", 5},
+		{"date:2010-13", 6},
+		{"date:2010-02-30", 6},
+		{"date:2011-02-29", 6},
+		{"date:2010-1-1-1", 6},
+		{"date:2012..2010", 6},
+	} {
+		_, err := Parse(tc.query)
+
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Errorf("Parse(%q) returned %v, want a *SyntaxError", tc.query, err)
+			continue
+		}
+		if se.Pos != tc.pos {
+			t.Errorf("Parse(%q) failed at position %d, want %d: %s", tc.query,
+				se.Pos, tc.pos, se)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package search implements a full-text inverted index over xkcd stories,
+// with results ranked using Okapi BM25.
+package search
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// BM25 tuning parameters, the usual defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+	"it": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"to": true, "was": true, "with": true,
+}
+
+// Tokenize splits a text into lowercase, stemmed terms. Stop words are
+// dropped.
+func Tokenize(text string) []string {
+	var res []string
+
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		w = strings.ToLower(w)
+		if stopWords[w] {
+			continue
+		}
+		res = append(res, Stem(w))
+	}
+
+	return res
+}
+
+// storyText returns all the indexed text of a story.
+func storyText(s *types.Story) string {
+	return strings.Join([]string{s.Title, s.SafeTitle, s.Alt, s.Transcript}, "\n")
+}
+
+// Fingerprint returns a hash of the number and the indexed text of a story.
+// The fingerprints of all the stories add up to the Digest of their index.
+func Fingerprint(num int, s *types.Story) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strconv.Itoa(num) + "\n" + storyText(s)))
+
+	return h.Sum64()
+}
+
+// Index is an inverted index mapping terms to the stories that contain them.
+type Index struct {
+	// Postings maps each term to the term frequency in every story that
+	// contains it.
+	Postings map[string]map[int]int
+	// DocLen holds the number of terms in every indexed story.
+	DocLen map[int]int
+	// TotalLen is the sum of all the DocLen values.
+	TotalLen int
+	// Fingerprints holds the Fingerprint of every indexed story.
+	Fingerprints map[int]uint64
+}
+
+// New returns an empty index.
+func New() *Index {
+	return &Index{
+		Postings:     map[string]map[int]int{},
+		DocLen:       map[int]int{},
+		Fingerprints: map[int]uint64{},
+	}
+}
+
+// Build creates an index of all the given stories.
+func Build(a types.AllStories) *Index {
+	res := New()
+
+	for k, v := range a {
+		res.Add(k, v)
+	}
+
+	return res
+}
+
+// Add indexes a story, replacing the previous version if it was already
+// indexed.
+func (idx *Index) Add(num int, s *types.Story) {
+	idx.Remove(num)
+
+	terms := Tokenize(storyText(s))
+	for _, t := range terms {
+		p, ok := idx.Postings[t]
+		if !ok {
+			p = map[int]int{}
+			idx.Postings[t] = p
+		}
+		p[num]++
+	}
+	idx.DocLen[num] = len(terms)
+	idx.TotalLen += len(terms)
+
+	if idx.Fingerprints == nil {
+		idx.Fingerprints = map[int]uint64{}
+	}
+	idx.Fingerprints[num] = Fingerprint(num, s)
+}
+
+// Remove drops a story from the index.
+func (idx *Index) Remove(num int) {
+	l, ok := idx.DocLen[num]
+	if !ok {
+		return
+	}
+
+	for t, p := range idx.Postings {
+		delete(p, num)
+		if len(p) == 0 {
+			delete(idx.Postings, t)
+		}
+	}
+	delete(idx.DocLen, num)
+	delete(idx.Fingerprints, num)
+	idx.TotalLen -= l
+}
+
+// Len returns the number of indexed stories.
+func (idx *Index) Len() int {
+	return len(idx.DocLen)
+}
+
+// Digest returns the sum of the fingerprints of the indexed stories, telling
+// whether the index matches a set of stories. Indexes stored before the
+// fingerprints were recorded have a zero digest.
+func (idx *Index) Digest() uint64 {
+	var res uint64
+
+	for _, f := range idx.Fingerprints {
+		res += f
+	}
+
+	return res
+}
+
+// Result is a single story matching a query, along with its relevance score.
+type Result struct {
+	Num   int
+	Score float64
+}
+
+// Search returns all the stories that contain at least one of the query
+// terms, sorted by decreasing BM25 score.
+func (idx *Index) Search(query string) []Result {
+	var res []Result
+
+	n := float64(idx.Len())
+	if n == 0 {
+		return nil
+	}
+	avgLen := float64(idx.TotalLen) / n
+
+	scores := map[int]float64{}
+	seen := map[string]bool{}
+	for _, t := range Tokenize(query) {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+
+		p := idx.Postings[t]
+		df := float64(len(p))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for num, tf := range p {
+			f := float64(tf)
+			norm := 1 - bm25B + bm25B*float64(idx.DocLen[num])/avgLen
+			scores[num] += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+	}
+
+	for num, score := range scores {
+		res = append(res, Result{Num: num, Score: score})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Num < res[j].Num
+	})
+
+	return res
+}
@@ -0,0 +1,393 @@
+// Package server exposes the xkcd index over HTTP, both as a JSON API and as a
+// set of simple HTML pages.
+//
+// JSON API:
+//
+//	/stories?q=&match=&threshold=&min=&max=  titles matching q, like xkcd list
+//	/stories/{num}                           a single story
+//	/search?q=&min=&max=                     stories matching q, like xkcd search
+//	/random?q=                               a random story, optionally matching q
+//	/latest                                  the newest story
+//
+// HTML pages:
+//
+//	/                    the newest comic
+//	/comics/{num}        a single comic
+//	/comics/random       redirects to a random comic
+//	/comics/search?q=    search results
+//	/images/...          mirrored images, if any
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/images"
+	"github.com/vespian/go-exercises/xkcd/pkg/index"
+	"github.com/vespian/go-exercises/xkcd/pkg/output"
+	"github.com/vespian/go-exercises/xkcd/pkg/search"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// allStories is the range used when the request does not limit it.
+var allStories = types.FullRange
+
+// Server serves a snapshot of the index that is loaded once, at creation.
+type Server struct {
+	a         types.AllStories
+	search    *search.Index
+	imagesDir string
+	mux       *http.ServeMux
+	log       types.Logger
+}
+
+// New creates a server for the given stories and their full-text index.
+// Mirrored images are served from `imagesDir`. Failures to write responses
+// are reported to the logger.
+func New(a types.AllStories, s *search.Index, imagesDir string, log types.Logger) *Server {
+	srv := &Server{
+		a:         a,
+		search:    s,
+		imagesDir: imagesDir,
+		mux:       http.NewServeMux(),
+		log:       log,
+	}
+
+	srv.mux.HandleFunc("/stories", srv.handleStories)
+	srv.mux.HandleFunc("/stories/", srv.handleStory)
+	srv.mux.HandleFunc("/search", srv.handleSearch)
+	srv.mux.HandleFunc("/random", srv.handleRandom)
+	srv.mux.HandleFunc("/latest", srv.handleLatest)
+	srv.mux.HandleFunc("/comics/", srv.handleComicPage)
+	srv.mux.Handle("/images/",
+		http.StripPrefix("/images/", http.FileServer(http.Dir(imagesDir))))
+	srv.mux.HandleFunc("/", srv.handleIndexPage)
+
+	return srv
+}
+
+// shutdownTimeout is how long Serve waits for the requests in progress to
+// finish once the context is cancelled.
+const shutdownTimeout = 5 * time.Second
+
+// Serve loads the index and serves it on the given address until it fails or
+// the context is cancelled, in which case the server is shut down gracefully.
+// The progress is reported to the logger of the index.
+func Serve(ctx context.Context, listen string, x *index.Index, imagesDir string) error {
+	a, s, err := x.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	hs := &http.Server{Addr: listen, Handler: New(a, s, imagesDir, x.Logger)}
+	done, stop := make(chan error, 1), make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+			// The server failed on its own, there is nothing to shut down.
+			return
+		}
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		done <- hs.Shutdown(sctx)
+	}()
+
+	x.Logger.Printf(types.LogProgress, "Serving %d stories on %s", len(a), listen)
+
+	if err = hs.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	x.Logger.Printf(types.LogProgress, "Shutting down")
+	return <-done
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+// requestRange returns the range of stories given by the `min` and `max`
+// query parameters.
+func requestRange(r *http.Request) (types.Range, error) {
+	rg := allStories
+
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"min", &rg.Min}, {"max", &rg.Max}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return rg, fmt.Errorf("invalid `%s` parameter: %s", p.name, v)
+		}
+		*p.dst = n
+	}
+
+	return rg, nil
+}
+
+// requestMatch returns the match parameters given by the `match` and
+// `threshold` query parameters.
+func requestMatch(r *http.Request) (types.MatchParams, error) {
+	mp := types.MatchParams{Mode: types.Substring, Threshold: 0.7}
+
+	if v := r.URL.Query().Get("match"); v != "" {
+		if err := mp.Mode.Set(v); err != nil {
+			return mp, err
+		}
+	}
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return mp, fmt.Errorf("invalid `threshold` parameter: %s", v)
+		}
+		mp.Threshold = t
+	}
+
+	return mp, nil
+}
+
+func (srv *Server) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		srv.log.Printf(types.LogNotice, "Writing response failed: %s", err)
+	}
+}
+
+func (srv *Server) writeHits(w http.ResponseWriter, h types.Hits) {
+	w.Header().Set("Content-Type", "application/json")
+	err := output.Write(w, h, types.OutputParams{Format: types.JSONOutput})
+	if err != nil {
+		srv.log.Printf(types.LogNotice, "Writing response failed: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func (srv *Server) handleStories(w http.ResponseWriter, r *http.Request) {
+	rg, err := requestRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	mp, err := requestMatch(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	h, err := index.FilterStories(srv.a, r.URL.Query().Get("q"), mp, rg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	srv.writeHits(w, h)
+}
+
+// storyNum extracts the story number from paths like `/stories/42`.
+func storyNum(path, prefix string) (int, bool) {
+	n, err := strconv.Atoi(strings.TrimPrefix(path, prefix))
+	return n, err == nil
+}
+
+func (srv *Server) handleStory(w http.ResponseWriter, r *http.Request) {
+	n, ok := storyNum(r.URL.Path, "/stories/")
+	if !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid story number"))
+		return
+	}
+
+	s, ok := srv.a[n]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("story %d not found", n))
+		return
+	}
+
+	srv.writeJSON(w, s)
+}
+
+func (srv *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	rg, err := requestRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	h, err := index.SearchStories(srv.a, srv.search, r.URL.Query().Get("q"), rg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	srv.writeHits(w, h)
+}
+
+// random picks a random story matching the query, or nil if none does.
+func (srv *Server) random(q string) (*types.Story, error) {
+	h, err := index.SearchStories(srv.a, srv.search, q, allStories)
+	if err != nil || len(h) == 0 {
+		return nil, err
+	}
+
+	return h[rand.Intn(len(h))].Story, nil
+}
+
+func (srv *Server) handleRandom(w http.ResponseWriter, r *http.Request) {
+	s, err := srv.random(r.URL.Query().Get("q"))
+	switch {
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	case s == nil:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no matching stories"))
+	default:
+		srv.writeJSON(w, s)
+	}
+}
+
+func (srv *Server) handleLatest(w http.ResponseWriter, r *http.Request) {
+	s, ok := srv.a[srv.a.MaxNum()]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the index is empty"))
+		return
+	}
+
+	srv.writeJSON(w, s)
+}
+
+// comicPage holds everything needed to render a single comic.
+type comicPage struct {
+	Story      *types.Story
+	ImageURL   string
+	Prev, Next int
+}
+
+func (srv *Server) newComicPage(s *types.Story) comicPage {
+	res := comicPage{Story: s, ImageURL: s.Img}
+
+	if images.Present(srv.imagesDir, s) {
+		res.ImageURL = "/images/" + s.LocalImage.Path
+	}
+	for n := s.Num - 1; n > 0 && res.Prev == 0; n-- {
+		if _, ok := srv.a[n]; ok {
+			res.Prev = n
+		}
+	}
+	for n, last := s.Num+1, srv.a.MaxNum(); n <= last && res.Next == 0; n++ {
+		if _, ok := srv.a[n]; ok {
+			res.Next = n
+		}
+	}
+
+	return res
+}
+
+func (srv *Server) renderPage(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, name, data); err != nil {
+		srv.log.Printf(types.LogNotice, "Rendering page failed: %s", err)
+	}
+}
+
+func (srv *Server) handleIndexPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	s, ok := srv.a[srv.a.MaxNum()]
+	if !ok {
+		http.Error(w, "The index is empty", http.StatusNotFound)
+		return
+	}
+
+	srv.renderPage(w, "comic", srv.newComicPage(s))
+}
+
+func (srv *Server) handleComicPage(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/comics/random":
+		s, err := srv.random(r.URL.Query().Get("q"))
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case s == nil:
+			http.Error(w, "No matching comics", http.StatusNotFound)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/comics/%d", s.Num), http.StatusFound)
+		return
+	case "/comics/search":
+		q := r.URL.Query().Get("q")
+		h, err := index.SearchStories(srv.a, srv.search, q, allStories)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		srv.renderPage(w, "search", struct {
+			Query string
+			Hits  types.Hits
+		}{q, h})
+		return
+	}
+
+	n, ok := storyNum(r.URL.Path, "/comics/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s, ok := srv.a[n]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	srv.renderPage(w, "comic", srv.newComicPage(s))
+}
+
+var pages = template.Must(template.New("pages").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.}}</title></head>
+<body>
+<form action="/comics/search"><input name="q"> <input type="submit" value="Search">
+<a href="/comics/random">Random</a> <a href="/">Latest</a></form>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "comic"}}{{template "header" .Story.Title}}
+<h1>{{.Story.Num}}: {{.Story.Title}}</h1>
+<p>{{.Story.Year}}-{{printf "%02d" .Story.Month}}-{{printf "%02d" .Story.Day}}</p>
+<p><img src="{{.ImageURL}}" alt="{{.Story.Title}}" title="{{.Story.Alt}}"></p>
+<p><em>{{.Story.Alt}}</em></p>
+<p>{{if .Prev}}<a href="/comics/{{.Prev}}">&lt; Prev</a>{{end}}
+{{if .Next}}<a href="/comics/{{.Next}}">Next &gt;</a>{{end}}</p>
+{{template "footer"}}{{end}}
+
+{{define "search"}}{{template "header" "Search"}}
+<h1>Results for “{{.Query}}”</h1>
+<ul>
+{{range .Hits}}<li><a href="/comics/{{.Story.Num}}">{{.Story.Num}}: {{.Story.Title}}</a></li>
+{{else}}<li>No matching comics.</li>
+{{end}}</ul>
+{{template "footer"}}{{end}}
+`))
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/index"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// newTestServer serves the stories of the JSON index fixture.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	x := &index.Index{File: types.IndexFile{
+		Location: "../../testdata/xkcd-index.json",
+		Type:     types.JSON,
+	}}
+	a, s, err := x.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(New(a, s, t.TempDir(), nil))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// get requests the path, checks the status code and decodes the JSON body
+// into `v`.
+func get(t *testing.T, ts *httptest.Server, path string, code int, v interface{}) {
+	t.Helper()
+
+	resp, err := http.Get(ts.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != code {
+		t.Fatalf("GET %s: status %d, want %d", path, resp.StatusCode, code)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s: content type %q, want application/json", path, ct)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: decoding the response failed: %s", path, err)
+	}
+}
+
+func TestStory(t *testing.T) {
+	ts := newTestServer(t)
+
+	var s types.Story
+	get(t, ts, "/stories/2", http.StatusOK, &s)
+	if s.Num != 2 || s.Title != "Petit Trees (sketch)" {
+		t.Errorf("got story %d %q, want 2 \"Petit Trees (sketch)\"", s.Num, s.Title)
+	}
+
+	var e map[string]string
+	get(t, ts, "/stories/42", http.StatusNotFound, &e)
+	if e["error"] == "" {
+		t.Errorf("missing error message for a missing story")
+	}
+	get(t, ts, "/stories/two", http.StatusBadRequest, &e)
+}
+
+func TestSearch(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, tc := range []struct {
+		path string
+		want []int
+	}{
+		{"/search?q=barrel", []int{1}},
+		{"/search?q=sketch", []int{2, 3, 4}},
+		{"/search?q=sketch&min=3", []int{3, 4}},
+		{"/search?q=sketch&max=3", []int{2, 3}},
+		{"/search?q=title:island", []int{3}},
+		{"/search?q=nothing+like+this", []int{}},
+	} {
+		var hits []types.Story
+		get(t, ts, tc.path, http.StatusOK, &hits)
+
+		got := map[int]bool{}
+		for _, h := range hits {
+			got[h.Num] = true
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("GET %s: got %d stories, want %v", tc.path, len(got), tc.want)
+			continue
+		}
+		for _, n := range tc.want {
+			if !got[n] {
+				t.Errorf("GET %s: story %d missing, want %v", tc.path, n, tc.want)
+			}
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	ts := newTestServer(t)
+
+	var s types.Story
+	get(t, ts, "/latest", http.StatusOK, &s)
+	if s.Num != 4 {
+		t.Errorf("got story %d, want 4", s.Num)
+	}
+}
+
+func TestInvalidRange(t *testing.T) {
+	ts := newTestServer(t)
+
+	for _, path := range []string{
+		"/search?q=sketch&min=x",
+		"/search?q=sketch&max=3.5",
+		"/stories?min=one",
+		"/stories?q=barrel&max=4x",
+	} {
+		var e map[string]string
+		get(t, ts, path, http.StatusBadRequest, &e)
+		if e["error"] == "" {
+			t.Errorf("GET %s: missing error message", path)
+		}
+	}
+}
+
+func TestRandomComic(t *testing.T) {
+	ts := newTestServer(t)
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	for _, tc := range []struct {
+		query string
+		code  int
+	}{
+		{"barrel", http.StatusFound},
+		{"nothing+like+this", http.StatusNotFound},
+		{"title:", http.StatusBadRequest},
+		{"%28barrel", http.StatusBadRequest},
+	} {
+		resp, err := c.Get(ts.URL + "/comics/random?q=" + tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.code {
+			t.Errorf("GET /comics/random?q=%s: status %d, want %d", tc.query,
+				resp.StatusCode, tc.code)
+		}
+	}
+}
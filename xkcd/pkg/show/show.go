@@ -0,0 +1,40 @@
+// Package show prints a single comic in the terminal: its title, date, image,
+// alt text and transcript.
+package show
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/termimg"
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// Write prints the story, drawing the image with the given options unless it
+// is nil.
+func Write(w io.Writer, s *types.Story, img image.Image, o termimg.Options) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "%d: %s\n", s.Num, s.Title)
+	fmt.Fprintf(bw, "%04d-%02d-%02d · https://xkcd.com/%d/\n\n", s.Year, s.Month, s.Day, s.Num)
+	if img != nil && o.Protocol != types.NoImage {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if err := termimg.Render(w, img, o); err != nil {
+			return err
+		}
+		bw.WriteString("\n")
+	}
+	if s.Alt != "" {
+		fmt.Fprintf(bw, "Alt: %s\n", s.Alt)
+	}
+	if t := strings.TrimSpace(s.Transcript); t != "" {
+		fmt.Fprintf(bw, "\nTranscript:\n%s\n", t)
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,45 @@
+package termimg
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// writeBlocks draws the image with the upper half block character, every
+// cell showing two pixels: the upper one in the foreground color and the
+// lower one in the background color. Colors are 24-bit, and only emitted when
+// they change.
+func writeBlocks(w io.Writer, img *image.RGBA) error {
+	bw := bufio.NewWriter(w)
+	b := img.Bounds()
+
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		var fg, bg *color.RGBA
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top := img.RGBAAt(x, y)
+			if fg == nil || *fg != top {
+				fmt.Fprintf(bw, "\x1b[38;2;%d;%d;%dm", top.R, top.G, top.B)
+				fg = &top
+			}
+			if y+1 < b.Max.Y {
+				bottom := img.RGBAAt(x, y+1)
+				if bg == nil || *bg != bottom {
+					fmt.Fprintf(bw, "\x1b[48;2;%d;%d;%dm", bottom.R, bottom.G, bottom.B)
+					bg = &bottom
+				}
+			} else if bg == nil {
+				// The last row of an image of odd height only has upper
+				// pixels.
+				bw.WriteString("\x1b[49m")
+				bg = &color.RGBA{}
+			}
+			bw.WriteString("▀")
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,57 @@
+package termimg
+
+import (
+	"os"
+	"strings"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// Detect picks the protocol supported by the terminal `f` is connected to,
+// guessing from the environment, as querying the terminal would need raw
+// mode. Nothing is drawn if `f` is not a terminal, and terminals that are not
+// known to support kitty graphics or sixels get half-block art.
+func Detect(f *os.File) types.ImageProtocol {
+	if !IsTerminal(f) {
+		return types.NoImage
+	}
+
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", term == "xterm-kitty",
+		term == "xterm-ghostty", program == "ghostty", program == "WezTerm":
+		return types.KittyProtocol
+	case strings.Contains(term, "sixel"), strings.HasPrefix(term, "foot"),
+		strings.HasPrefix(term, "mlterm"), program == "iTerm.app":
+		return types.SixelProtocol
+	default:
+		return types.BlocksProtocol
+	}
+}
+
+// IsTerminal tells whether the file is a terminal.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// NewOptions resolves the parameters for drawing on the terminal `f`: the
+// protocol is detected if it was not given, and the width is that of the
+// terminal if it was not given, 80 columns if it is unknown.
+func NewOptions(p types.ShowParams, f *os.File) Options {
+	o := Options{Protocol: p.Protocol, Columns: p.Width}
+
+	if o.Protocol == types.AutoProtocol {
+		o.Protocol = Detect(f)
+	}
+	columns, cellWidth := Size(f)
+	if o.Columns <= 0 {
+		o.Columns = columns
+	}
+	if o.Columns <= 0 {
+		o.Columns = 80
+	}
+	o.CellWidth = cellWidth
+
+	return o
+}
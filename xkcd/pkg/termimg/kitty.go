@@ -0,0 +1,49 @@
+package termimg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+)
+
+// kittyChunk is the maximum size of the base64 payload of a single escape
+// sequence of the kitty graphics protocol.
+const kittyChunk = 4096
+
+// writeKitty transmits the image as PNG and displays it at the cursor, see
+// https://sw.kovidgoyal.net/kitty/graphics-protocol/. Responses of the
+// terminal are suppressed.
+func writeKitty(w io.Writer, img image.Image) error {
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	for first := true; first || payload != ""; first = false {
+		chunk := payload
+		if len(chunk) > kittyChunk {
+			chunk = chunk[:kittyChunk]
+		}
+		payload = payload[len(chunk):]
+
+		more := 0
+		if payload != "" {
+			more = 1
+		}
+		control := fmt.Sprintf("m=%d", more)
+		if first {
+			control = "a=T,f=100,q=2," + control
+		}
+		if _, err := fmt.Fprintf(w, "\x1b_G%s;%s\x1b\\", control, chunk); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
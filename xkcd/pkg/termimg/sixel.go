@@ -0,0 +1,97 @@
+package termimg
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"io"
+)
+
+// writeSixel draws the image with sixels, mapping every pixel to the nearest
+// color of the web-safe palette, which is deterministic and preserves the
+// black and white line art of most comics.
+func writeSixel(w io.Writer, img *image.RGBA) error {
+	b := img.Bounds()
+	p := image.NewPaletted(b, palette.WebSafe)
+	draw.Draw(p, b, img, b.Min, draw.Src)
+
+	bw := bufio.NewWriter(w)
+
+	// Raster attributes: square pixels and the size of the image.
+	fmt.Fprintf(bw, "\x1bPq\"1;1;%d;%d", b.Dx(), b.Dy())
+
+	used := make([]bool, len(p.Palette))
+	for _, i := range p.Pix {
+		used[i] = true
+	}
+	for i, c := range p.Palette {
+		if !used[i] {
+			continue
+		}
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(bw, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	row := make([]byte, b.Dx())
+	for y := 0; y < b.Dy(); y += 6 {
+		if y > 0 {
+			bw.WriteByte('-')
+		}
+
+		first := true
+		for i := range p.Palette {
+			if !used[i] || !sixelRow(p, y, uint8(i), row) {
+				continue
+			}
+			if !first {
+				bw.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(bw, "#%d", i)
+			writeSixelRLE(bw, row)
+		}
+	}
+	bw.WriteString("\x1b\\\n")
+
+	return bw.Flush()
+}
+
+// sixelRow fills `row` with the sixels of the color `c` in the band of six
+// rows starting at `y`, and tells whether the color is used in the band.
+func sixelRow(p *image.Paletted, y int, c uint8, row []byte) bool {
+	found := false
+
+	for x := range row {
+		bits := byte(0)
+		for k := 0; k < 6 && y+k < p.Rect.Dy(); k++ {
+			if p.ColorIndexAt(p.Rect.Min.X+x, p.Rect.Min.Y+y+k) == c {
+				bits |= 1 << uint(k)
+			}
+		}
+		row[x] = '?' + bits
+		found = found || bits != 0
+	}
+
+	return found
+}
+
+// writeSixelRLE writes the sixels, with runs of more than three identical ones
+// compressed.
+func writeSixelRLE(w *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i + 1
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, row[i])
+		} else {
+			for k := i; k < j; k++ {
+				w.WriteByte(row[i])
+			}
+		}
+		i = j
+	}
+}
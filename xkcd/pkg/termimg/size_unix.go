@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package termimg
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+type winsize struct {
+	Row, Col, Xpixel, Ypixel uint16
+}
+
+// Size returns the width of the terminal in cells, and the width of a cell
+// in pixels, zero if the terminal does not report it. The width is zero if
+// `f` is not a terminal.
+func Size(f *os.File) (columns, cellWidth int) {
+	var ws winsize
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(),
+		uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 || ws.Col == 0 {
+		return 0, 0
+	}
+
+	return int(ws.Col), int(ws.Xpixel) / int(ws.Col)
+}
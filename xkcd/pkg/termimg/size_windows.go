@@ -0,0 +1,9 @@
+package termimg
+
+import "os"
+
+// Size does not query the console on Windows, the width of the terminal is
+// unknown there.
+func Size(f *os.File) (columns, cellWidth int) {
+	return 0, 0
+}
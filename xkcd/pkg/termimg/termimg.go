@@ -0,0 +1,107 @@
+// Package termimg draws images in the terminal, with the kitty graphics
+// protocol, with sixels, or as ANSI half-block art for terminals supporting
+// neither. The output only depends on the image and the options, so that it
+// can be compared with a snapshot.
+package termimg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// defaultCellWidth is the width of a terminal cell in pixels assumed when the
+// terminal does not report it.
+const defaultCellWidth = 10
+
+// Options control how an image is drawn.
+type Options struct {
+	// Protocol is how the image is drawn, it has to be resolved already,
+	// see Detect.
+	Protocol types.ImageProtocol
+	// Columns is the maximum width of the image in terminal cells. Images
+	// are scaled down to fit, never up.
+	Columns int
+	// CellWidth is the width of a terminal cell in pixels, used to size the
+	// images drawn with kitty and sixels. Zero means defaultCellWidth.
+	CellWidth int
+}
+
+// Render draws the image.
+func Render(w io.Writer, img image.Image, o Options) error {
+	if o.Columns <= 0 {
+		return fmt.Errorf("invalid image width of %d columns", o.Columns)
+	}
+	cellWidth := o.CellWidth
+	if cellWidth <= 0 {
+		cellWidth = defaultCellWidth
+	}
+
+	switch o.Protocol {
+	case types.KittyProtocol:
+		return writeKitty(w, fit(img, o.Columns*cellWidth))
+	case types.SixelProtocol:
+		return writeSixel(w, fit(img, o.Columns*cellWidth))
+	case types.BlocksProtocol:
+		return writeBlocks(w, fit(img, o.Columns))
+	case types.NoImage:
+		return nil
+	default:
+		return fmt.Errorf("%w: image protocol `%s`", types.ErrUnsupportedFormat, o.Protocol)
+	}
+}
+
+// fit scales the image down to at most `width` pixels, keeping its aspect
+// ratio, and flattens it onto a white background, as terminals can not be
+// relied on to blend transparent pixels. Every pixel of the result is the
+// average of the pixels of the image it covers.
+func fit(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	dw, dh := sw, sh
+	if sw > width {
+		dw = width
+		dh = (sh*width + sw/2) / sw
+		if dh < 1 {
+			dh = 1
+		}
+	}
+
+	res := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			if x1 == x0 {
+				x1++
+			}
+
+			var r, g, bl, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					// Premultiplied colors, blended onto white.
+					r += uint64(pr + 0xffff - pa)
+					g += uint64(pg + 0xffff - pa)
+					bl += uint64(pb + 0xffff - pa)
+					n++
+				}
+			}
+			res.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return res
+}
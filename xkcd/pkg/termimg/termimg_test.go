@@ -0,0 +1,86 @@
+package termimg
+
+import (
+	"bytes"
+	"flag"
+	"image"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+// testdata is the directory of the fixtures shared by the xkcd packages.
+const testdata = "../../testdata"
+
+func loadFixture(t *testing.T) image.Image {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join(testdata, "termimg.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestRenderGolden(t *testing.T) {
+	img := loadFixture(t)
+
+	for _, tc := range []struct {
+		name string
+		o    Options
+	}{
+		{"kitty", Options{Protocol: types.KittyProtocol, Columns: 4, CellWidth: 8}},
+		{"sixel", Options{Protocol: types.SixelProtocol, Columns: 4, CellWidth: 8}},
+		{"blocks", Options{Protocol: types.BlocksProtocol, Columns: 24, CellWidth: 8}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Render(&buf, img, tc.o); err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join(testdata, "termimg."+tc.name+".golden")
+			if *update {
+				if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("output differs from %s, got:\n%q", golden, buf.String())
+			}
+		})
+	}
+}
+
+func TestRenderNoImage(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := Render(&buf, loadFixture(t), Options{Protocol: types.NoImage, Columns: 10})
+	if err != nil || buf.Len() != 0 {
+		t.Errorf("got %q, %v, want no output", buf.String(), err)
+	}
+}
+
+func TestRenderInvalidWidth(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := Render(&buf, loadFixture(t), Options{Protocol: types.BlocksProtocol}); err == nil {
+		t.Errorf("rendering with no columns succeeded")
+	}
+}
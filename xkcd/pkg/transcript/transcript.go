@@ -0,0 +1,175 @@
+// Package transcript parses the transcripts of the xkcd stories, written in
+// the informal markup of the xkcd API:
+//
+//	[[A scene description.]]
+//	Cueball: A line of dialogue.
+//	<<A sound effect>>
+//	A caption, not attributed to anyone.
+//
+//	{{Title text: an annotation, usually the title text.}}
+//
+// Panels are separated by blank lines. The markup is not used consistently,
+// so the parser never fails, it makes the best of what it gets.
+package transcript
+
+import (
+	"strings"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// maxSpeakerWords is the longest a speaker name can be, longer prefixes ending
+// with a colon start captions, like `Things you should never say at a
+// wedding:`.
+const maxSpeakerWords = 4
+
+// delimiter encloses a marked up element.
+type delimiter struct{ open, close string }
+
+// delimiters of the markup, the kind of the element they mark is decided in
+// addMarked.
+var delimiters = []delimiter{
+	{"[[", "]]"},
+	{"{{", "}}"},
+	{"<<", ">>"},
+}
+
+// Parse parses the transcript, returning nil for an empty one.
+func Parse(raw string) *types.Script {
+	res := &types.Script{}
+
+	raw = strings.Replace(raw, "\r\n", "\n", -1)
+	for _, block := range splitPanels(raw) {
+		p := types.Panel{}
+		for _, line := range strings.Split(extract(block, &p, res), "\n") {
+			addLine(&p, line)
+		}
+		if len(p.Scenes)+len(p.Lines)+len(p.Sounds)+len(p.Captions) > 0 {
+			res.Panels = append(res.Panels, p)
+		}
+	}
+
+	if len(res.Panels) == 0 && len(res.Annotations) == 0 {
+		return nil
+	}
+	return res
+}
+
+// splitPanels splits the transcript on blank lines, leaving the ones inside
+// the markup alone.
+func splitPanels(raw string) []string {
+	var res []string
+
+	start, pos, depth := 0, 0, 0
+	for _, l := range strings.SplitAfter(raw, "\n") {
+		if strings.TrimSpace(l) == "" && depth == 0 {
+			res = append(res, raw[start:pos])
+			start = pos + len(l)
+		}
+		for _, d := range delimiters {
+			depth += strings.Count(l, d.open) - strings.Count(l, d.close)
+		}
+		if depth < 0 {
+			depth = 0
+		}
+		pos += len(l)
+	}
+
+	return append(res, raw[start:])
+}
+
+// extract moves the marked up elements of the block into the panel, or the
+// annotations into the script, and returns what is left of the block.
+func extract(block string, p *types.Panel, s *types.Script) string {
+	var rest strings.Builder
+
+	for len(block) > 0 {
+		i, d := firstOpening(block)
+		if i < 0 {
+			rest.WriteString(block)
+			break
+		}
+		end := strings.Index(block[i+len(d.open):], d.close)
+		if end < 0 {
+			// Unterminated, the rest is taken as plain text.
+			rest.WriteString(block)
+			break
+		}
+
+		rest.WriteString(block[:i])
+		addMarked(p, s, d.open, block[i+len(d.open):i+len(d.open)+end])
+		block = block[i+len(d.open)+end+len(d.close):]
+	}
+
+	return rest.String()
+}
+
+// firstOpening returns the position of the first opening delimiter in `s`,
+// or -1.
+func firstOpening(s string) (int, delimiter) {
+	pos := -1
+	var res delimiter
+
+	for _, d := range delimiters {
+		if i := strings.Index(s, d.open); i >= 0 && (pos < 0 || i < pos) {
+			pos, res = i, d
+		}
+	}
+
+	return pos, res
+}
+
+func addMarked(p *types.Panel, s *types.Script, open, text string) {
+	text = normalize(text)
+	if text == "" {
+		return
+	}
+
+	switch open {
+	case "[[":
+		p.Scenes = append(p.Scenes, text)
+	case "{{":
+		s.Annotations = append(s.Annotations, text)
+	case "<<":
+		p.Sounds = append(p.Sounds, text)
+	}
+}
+
+// addLine adds a line left after extracting the markup, either dialogue or a
+// caption.
+func addLine(p *types.Panel, line string) {
+	line = normalize(line)
+	if line == "" {
+		return
+	}
+
+	if speaker, text, ok := splitSpeaker(line); ok {
+		p.Lines = append(p.Lines, types.Utterance{Speaker: speaker, Text: text})
+		return
+	}
+	p.Captions = append(p.Captions, line)
+}
+
+// splitSpeaker splits `Speaker: text` lines. Speakers are short, and do not
+// end sentences, so that captions containing colons are not mistaken for
+// dialogue.
+func splitSpeaker(line string) (string, string, bool) {
+	i := strings.Index(line, ":")
+	if i <= 0 || (i+1 < len(line) && line[i+1] != ' ') {
+		return "", "", false
+	}
+
+	speaker := strings.TrimSpace(line[:i])
+	if speaker == "" || len(strings.Fields(speaker)) > maxSpeakerWords ||
+		strings.ContainsAny(speaker, `?!"…`) || strings.HasPrefix(speaker, "...") {
+		return "", "", false
+	}
+
+	return speaker, strings.TrimSpace(line[i+1:]), true
+}
+
+// normalize collapses the whitespace, including line breaks inside the
+// markup.
+func normalize(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
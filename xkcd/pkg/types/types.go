@@ -2,16 +2,66 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// ToolVersion is the version of xkcd recorded in the indexes it writes. It is
+// meant to be set at build time with `-ldflags "-X ..."`.
+var ToolVersion = "dev"
+
+// Errors that programs embedding the xkcd packages can check for with
+// errors.Is.
+var (
+	// ErrNotFound means the requested story, or index, does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrCorruptIndex means the index file could not be decoded.
+	ErrCorruptIndex = errors.New("corrupt index")
+	// ErrUnsupportedFormat means the index, or the requested output, is in a
+	// format this version of xkcd does not support.
+	ErrUnsupportedFormat = errors.New("unsupported format")
+)
+
+// LogLevel tells how important a log message is.
+type LogLevel int
+
+const (
+	// LogProgress messages describe the work being done.
+	LogProgress LogLevel = 1 + iota
+	// LogNotice messages describe conditions that deserve attention, like an
+	// index being upgraded or a story that could not be processed.
+	LogNotice
+)
+
+// Logger receives the messages of long running operations, so that programs
+// embedding the xkcd packages decide what to do with them. A nil Logger
+// discards them.
+type Logger func(level LogLevel, msg string)
+
+// Printf formats the message and passes it to the logger, if any.
+func (l Logger) Printf(level LogLevel, format string, args ...interface{}) {
+	if l != nil {
+		l(level, fmt.Sprintf(format, args...))
+	}
+}
+
 type OndiskSerialization int
 
 const (
 	Protobuf OndiskSerialization = 1 + iota
 	JSON
+	Bolt
+	// PBStream and JSONLStream store the stories as a stream of records, one
+	// per story, so that they can be read one at a time.
+	PBStream
+	JSONLStream
 )
 
 type OperationType int
@@ -20,6 +70,101 @@ const (
 	Update OperationType = 1 + iota
 	Search
 	List
+	Convert
+	Restore
+	Mirror
+	Serve
+	Sync
+	History
+	Verify
+	Benchmark
+	Export
+	Import
+	Show
+	Random
+	Characters
+)
+
+type MatchMode int
+
+const (
+	Exact MatchMode = 1 + iota
+	Substring
+	Regex
+	Fuzzy
+)
+
+type SortKey int
+
+const (
+	ByNum SortKey = 1 + iota
+	ByDate
+	ByRelevance
+)
+
+// SortOrder is the direction of sorting, the zero value means the natural
+// order of the sort key.
+type SortOrder int
+
+const (
+	DefaultOrder SortOrder = iota
+	Ascending
+	Descending
+)
+
+// Compression of the index file, the zero value means it is chosen by the
+// extension of the file.
+type Compression int
+
+const (
+	AutoCompression Compression = iota
+	NoCompression
+	Gzip
+	Zstd
+)
+
+type OutputFormat int
+
+const (
+	Human OutputFormat = 1 + iota
+	Table
+	JSONOutput
+	JSONLines
+	CSV
+	Template
+)
+
+// ExportFormat is the format of the documents created by the export
+// operation.
+type ExportFormat int
+
+const (
+	HTMLExport ExportFormat = 1 + iota
+	EPUBExport
+	CSVExport
+	MarkdownExport
+)
+
+// ConflictPolicy decides what happens when an imported story is already in
+// the index.
+type ConflictPolicy int
+
+const (
+	KeepExisting ConflictPolicy = 1 + iota
+	Overwrite
+	NewestWins
+)
+
+// ImageProtocol is how images are drawn in the terminal, the zero value means
+// it is detected from the terminal.
+type ImageProtocol int
+
+const (
+	AutoProtocol ImageProtocol = iota
+	KittyProtocol
+	SixelProtocol
+	BlocksProtocol
+	NoImage
 )
 
 func (s OndiskSerialization) String() string {
@@ -28,6 +173,12 @@ func (s OndiskSerialization) String() string {
 		return "protobuf"
 	case JSON:
 		return "json"
+	case Bolt:
+		return "bolt"
+	case PBStream:
+		return "pbstream"
+	case JSONLStream:
+		return "jsonl"
 	default:
 		return "unknown"
 	}
@@ -39,12 +190,49 @@ func (s *OndiskSerialization) Set(in string) error {
 		*s = Protobuf
 	case "json":
 		*s = JSON
+	case "bolt":
+		*s = Bolt
+	case "pbstream":
+		*s = PBStream
+	case "jsonl":
+		*s = JSONLStream
 	default:
 		return fmt.Errorf("unrecognized serialization type `%s`", in)
 	}
 	return nil
 }
 
+func (c Compression) String() string {
+	switch c {
+	case AutoCompression:
+		return "auto"
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+func (c *Compression) Set(in string) error {
+	switch strings.ToLower(in) {
+	case "auto":
+		*c = AutoCompression
+	case "none":
+		*c = NoCompression
+	case "gzip":
+		*c = Gzip
+	case "zstd":
+		*c = Zstd
+	default:
+		return fmt.Errorf("unrecognized compression `%s`", in)
+	}
+	return nil
+}
+
 func (s OperationType) String() string {
 	switch s {
 	case Update:
@@ -53,6 +241,32 @@ func (s OperationType) String() string {
 		return "search"
 	case List:
 		return "list"
+	case Convert:
+		return "convert"
+	case Restore:
+		return "restore"
+	case Mirror:
+		return "mirror"
+	case Serve:
+		return "serve"
+	case Sync:
+		return "sync"
+	case History:
+		return "history"
+	case Verify:
+		return "verify"
+	case Benchmark:
+		return "benchmark"
+	case Export:
+		return "export"
+	case Import:
+		return "import"
+	case Show:
+		return "show"
+	case Random:
+		return "random"
+	case Characters:
+		return "characters"
 	default:
 		return "unknown"
 	}
@@ -66,30 +280,468 @@ func (s *OperationType) Set(in string) error {
 		*s = Search
 	case "list":
 		*s = List
+	case "convert":
+		*s = Convert
+	case "restore":
+		*s = Restore
+	case "mirror":
+		*s = Mirror
+	case "serve":
+		*s = Serve
+	case "sync":
+		*s = Sync
+	case "history":
+		*s = History
+	case "verify":
+		*s = Verify
+	case "benchmark":
+		*s = Benchmark
+	case "export":
+		*s = Export
+	case "import":
+		*s = Import
+	case "show":
+		*s = Show
+	case "random":
+		*s = Random
+	case "characters":
+		*s = Characters
 	default:
 		return fmt.Errorf("unrecognized operation `%s`", in)
 	}
 	return nil
 }
 
+func (m MatchMode) String() string {
+	switch m {
+	case Exact:
+		return "exact"
+	case Substring:
+		return "substring"
+	case Regex:
+		return "regex"
+	case Fuzzy:
+		return "fuzzy"
+	default:
+		return "unknown"
+	}
+}
+
+func (m *MatchMode) Set(in string) error {
+	switch strings.ToLower(in) {
+	case "exact":
+		*m = Exact
+	case "substring":
+		*m = Substring
+	case "regex":
+		*m = Regex
+	case "fuzzy":
+		*m = Fuzzy
+	default:
+		return fmt.Errorf("unrecognized match mode `%s`", in)
+	}
+	return nil
+}
+
+func (k SortKey) String() string {
+	switch k {
+	case ByNum:
+		return "num"
+	case ByDate:
+		return "date"
+	case ByRelevance:
+		return "relevance"
+	default:
+		return "unknown"
+	}
+}
+
+func (k *SortKey) Set(in string) error {
+	switch strings.ToLower(in) {
+	case "num":
+		*k = ByNum
+	case "date":
+		*k = ByDate
+	case "relevance":
+		*k = ByRelevance
+	default:
+		return fmt.Errorf("unrecognized sort key `%s`", in)
+	}
+	return nil
+}
+
+func (o SortOrder) String() string {
+	switch o {
+	case DefaultOrder:
+		return "default"
+	case Ascending:
+		return "asc"
+	case Descending:
+		return "desc"
+	default:
+		return "unknown"
+	}
+}
+
+func (o *SortOrder) Set(in string) error {
+	switch strings.ToLower(in) {
+	case "default":
+		*o = DefaultOrder
+	case "asc":
+		*o = Ascending
+	case "desc":
+		*o = Descending
+	default:
+		return fmt.Errorf("unrecognized sort order `%s`", in)
+	}
+	return nil
+}
+
+func (f OutputFormat) String() string {
+	switch f {
+	case Human:
+		return "human"
+	case Table:
+		return "table"
+	case JSONOutput:
+		return "json"
+	case JSONLines:
+		return "jsonl"
+	case CSV:
+		return "csv"
+	case Template:
+		return "template"
+	default:
+		return "unknown"
+	}
+}
+
+func (f *OutputFormat) Set(in string) error {
+	switch strings.ToLower(in) {
+	case "human":
+		*f = Human
+	case "table":
+		*f = Table
+	case "json":
+		*f = JSONOutput
+	case "jsonl":
+		*f = JSONLines
+	case "csv":
+		*f = CSV
+	case "template":
+		*f = Template
+	default:
+		return fmt.Errorf("unrecognized output format `%s`", in)
+	}
+	return nil
+}
+
+func (f ExportFormat) String() string {
+	switch f {
+	case HTMLExport:
+		return "html"
+	case EPUBExport:
+		return "epub"
+	case CSVExport:
+		return "csv"
+	case MarkdownExport:
+		return "markdown"
+	default:
+		return "unknown"
+	}
+}
+
+func (f *ExportFormat) Set(in string) error {
+	switch strings.ToLower(in) {
+	case "html":
+		*f = HTMLExport
+	case "epub":
+		*f = EPUBExport
+	case "csv":
+		*f = CSVExport
+	case "markdown", "md":
+		*f = MarkdownExport
+	default:
+		return fmt.Errorf("unrecognized export format `%s`", in)
+	}
+	return nil
+}
+
+// DefaultOut returns the location the export is written to if none was given.
+func (f ExportFormat) DefaultOut() string {
+	switch f {
+	case EPUBExport:
+		return "xkcd-export.epub"
+	case CSVExport:
+		return "xkcd-export.csv"
+	case MarkdownExport:
+		return "xkcd-export.md"
+	default:
+		return "xkcd-export"
+	}
+}
+
+func (p ConflictPolicy) String() string {
+	switch p {
+	case KeepExisting:
+		return "keep-existing"
+	case Overwrite:
+		return "overwrite"
+	case NewestWins:
+		return "newest-wins"
+	default:
+		return "unknown"
+	}
+}
+
+func (p ImageProtocol) String() string {
+	switch p {
+	case AutoProtocol:
+		return "auto"
+	case KittyProtocol:
+		return "kitty"
+	case SixelProtocol:
+		return "sixel"
+	case BlocksProtocol:
+		return "blocks"
+	case NoImage:
+		return "none"
+	default:
+		return "unknown"
+	}
+}
+
+func (p *ImageProtocol) Set(in string) error {
+	switch strings.ToLower(in) {
+	case "auto":
+		*p = AutoProtocol
+	case "kitty":
+		*p = KittyProtocol
+	case "sixel":
+		*p = SixelProtocol
+	case "blocks":
+		*p = BlocksProtocol
+	case "none":
+		*p = NoImage
+	default:
+		return fmt.Errorf("unrecognized image protocol `%s`", in)
+	}
+	return nil
+}
+
+func (p *ConflictPolicy) Set(in string) error {
+	switch strings.ToLower(in) {
+	case "keep-existing":
+		*p = KeepExisting
+	case "overwrite":
+		*p = Overwrite
+	case "newest-wins":
+		*p = NewestWins
+	default:
+		return fmt.Errorf("unrecognized conflict policy `%s`", in)
+	}
+	return nil
+}
+
+// SortParams bundles together all the parameters describing how results are
+// ordered.
+type SortParams struct {
+	Key   SortKey
+	Order SortOrder
+}
+
+func (s SortParams) String() string {
+	return fmt.Sprintf("key: %s, order: %s", s.Key, s.Order)
+}
+
+// OutputParams bundles together all the parameters describing how results are
+// printed.
+type OutputParams struct {
+	Format   OutputFormat
+	Template string
+}
+
+func (o OutputParams) String() string {
+	return fmt.Sprintf("format: %s, template: %q", o.Format, o.Template)
+}
+
+// ExportParams bundles together all the parameters of the export operation.
+type ExportParams struct {
+	Format ExportFormat
+	// Out is the directory of the static site, or the file of the other
+	// formats.
+	Out string
+}
+
+func (e ExportParams) String() string {
+	return fmt.Sprintf("format: %s, out: `%s`", e.Format, e.Out)
+}
+
+// Sources lists the locations the import operation reads stories from, every
+// use of the flag adds one.
+type Sources []string
+
+func (s Sources) String() string {
+	return strings.Join(s, ", ")
+}
+
+func (s *Sources) Set(in string) error {
+	*s = append(*s, in)
+	return nil
+}
+
+// ImportParams bundles together all the parameters of the import operation.
+type ImportParams struct {
+	From   Sources
+	Policy ConflictPolicy
+}
+
+func (i ImportParams) String() string {
+	return fmt.Sprintf("from: `%s`, policy: %s", i.From, i.Policy)
+}
+
+// ShowParams bundles together the parameters of showing a comic in the
+// terminal.
+type ShowParams struct {
+	Protocol ImageProtocol
+	// Width is the maximum width of the image in terminal columns, zero
+	// means the width of the terminal.
+	Width int
+}
+
+func (s ShowParams) String() string {
+	return fmt.Sprintf("protocol: %s, width: %d", s.Protocol, s.Width)
+}
+
+// MatchParams bundles together all the parameters describing how the query
+// is matched against story titles.
+type MatchParams struct {
+	Mode      MatchMode
+	Threshold float64
+}
+
+func (m MatchParams) String() string {
+	return fmt.Sprintf("mode: %s, threshold: %g", m.Mode, m.Threshold)
+}
+
+// DefaultFileMode is used for index files if no mode was given.
+const DefaultFileMode = 0640
+
+// FileMode holds the permissions of the index files, it can be set from an
+// octal string.
+type FileMode os.FileMode
+
+func (m FileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(m.Perm()))
+}
+
+func (m *FileMode) Set(in string) error {
+	v, err := strconv.ParseUint(in, 8, 32)
+	if err != nil || v > 0777 {
+		return fmt.Errorf("invalid file mode `%s`", in)
+	}
+	*m = FileMode(v)
+	return nil
+}
+
+// Perm returns the permission bits, or DefaultFileMode if none were set.
+func (m FileMode) Perm() os.FileMode {
+	if m == 0 {
+		return DefaultFileMode
+	}
+	return os.FileMode(m).Perm()
+}
+
 // IndexFile bundles together all the parameters describing an index.
 type IndexFile struct {
-	Type     OndiskSerialization
-	Location string
+	Type        OndiskSerialization
+	Location    string
+	Mode        FileMode
+	Compression Compression
 }
 
 func (r IndexFile) String() string {
-	return fmt.Sprintf("type: %s, location: %s", r.Type, r.Location)
+	return fmt.Sprintf("type: %s, location: %s, mode: %s, compression: %s",
+		r.Type, r.Location, r.Mode, r.Compression)
 }
 
+// Range is the story numbers from Min to Max, inclusive.
 type Range struct {
 	Min, Max int
 }
 
+// FullRange covers all the stories, it is the range of operations that are
+// not limited to some of them.
+var FullRange = Range{Min: 0, Max: math.MaxInt}
+
 func (r Range) String() string {
 	return fmt.Sprintf("min: %d, max: %d", r.Min, r.Max)
 }
 
+// FetchParams bundles together all the parameters controlling how stories are
+// fetched from xkcd.
+type FetchParams struct {
+	Workers         int
+	Rate            float64
+	Retries         int
+	CheckpointEvery int
+	// WithImages enables mirroring images into ImagesDir while updating.
+	WithImages bool
+	ImagesDir  string
+	// CacheDir is the directory of the HTTP cache of the fetched stories,
+	// empty disables caching.
+	CacheDir string
+}
+
+func (f FetchParams) String() string {
+	return fmt.Sprintf("workers: %d, rate: %g/s, retries: %d, checkpoint every: %d, "+
+		"with images: %t, images dir: %s, cache dir: %s",
+		f.Workers, f.Rate, f.Retries, f.CheckpointEvery, f.WithImages, f.ImagesDir,
+		f.CacheDir)
+}
+
+// Sinks is a list of notification sink specifications, see package notify.
+// Every use of the flag adds another sink.
+type Sinks []string
+
+func (s Sinks) String() string {
+	return strings.Join(s, ", ")
+}
+
+func (s *Sinks) Set(in string) error {
+	*s = append(*s, in)
+	return nil
+}
+
+// SyncParams bundles together the parameters of the sync daemon.
+type SyncParams struct {
+	// Interval is the time between the starts of consecutive syncs.
+	Interval time.Duration
+	// Refresh is the number of the most recent stories that are fetched again
+	// on every sync to detect edits.
+	Refresh int
+	Notify  Sinks
+}
+
+func (s SyncParams) String() string {
+	return fmt.Sprintf("interval: %s, refresh: %d, notify: %s",
+		s.Interval, s.Refresh, s.Notify)
+}
+
+// Image describes a local copy of a comic image.
+type Image struct {
+	// Path is relative to the image store directory.
+	Path          string
+	Size          int64
+	Width, Height int
+	SHA256        string
+}
+
+func (i Image) String() string {
+	return fmt.Sprintf("%s (%dx%d, %d bytes, sha256 %s)",
+		i.Path, i.Width, i.Height, i.Size, i.SHA256)
+}
+
 type Story struct {
 	Alt        string
 	Day        int `json:",string"`
@@ -102,6 +754,117 @@ type Story struct {
 	Title      string
 	Transcript string
 	Year       int `json:",string"`
+	// LocalImage is set once the image has been mirrored.
+	LocalImage *Image `json:"local_image,omitempty"`
+	// Revisions holds the earlier versions of the story, oldest first. The
+	// revisions have no revisions of their own.
+	Revisions []Revision `json:"revisions,omitempty"`
+	// Script is the parsed Transcript, set when the story is stored.
+	Script *Script `json:"script,omitempty"`
+}
+
+// Script is the structured form of a transcript.
+type Script struct {
+	Panels []Panel `json:"panels,omitempty"`
+	// Annotations are the `{{...}}` notes, usually the title text.
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// Panel holds what the transcript says about a single panel, in the order it
+// says it.
+type Panel struct {
+	// Scenes are the `[[...]]` descriptions of what is drawn.
+	Scenes []string `json:"scenes,omitempty"`
+	// Lines are the `Speaker: text` dialogue lines.
+	Lines []Utterance `json:"lines,omitempty"`
+	// Sounds are the `<<...>>` sound effects.
+	Sounds []string `json:"sounds,omitempty"`
+	// Captions are the lines not attributed to anyone.
+	Captions []string `json:"captions,omitempty"`
+}
+
+// Utterance is a single line of dialogue.
+type Utterance struct {
+	Speaker string `json:"speaker"`
+	Text    string `json:"text"`
+}
+
+// Speakers returns the distinct speakers of the script, in the order they
+// first speak. Names differing only in case are the same speaker.
+func (s *Script) Speakers() []string {
+	var res []string
+
+	if s == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, p := range s.Panels {
+		for _, l := range p.Lines {
+			if k := strings.ToLower(l.Speaker); !seen[k] {
+				seen[k] = true
+				res = append(res, l.Speaker)
+			}
+		}
+	}
+
+	return res
+}
+
+// Character holds the statistics of a single speaker across the stories.
+type Character struct {
+	Name string `json:"name"`
+	// Lines is the number of dialogue lines, Stories the number of stories
+	// with any of them.
+	Lines   int `json:"lines"`
+	Stories int `json:"stories"`
+	// First and Last are the numbers of the first and last stories the
+	// character speaks in.
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+func (c Character) String() string {
+	return fmt.Sprintf("%s: %d lines in %d stories (%d..%d)", c.Name, c.Lines,
+		c.Stories, c.First, c.Last)
+}
+
+// Revision is an earlier version of a story, kept when an update replaced it
+// with an edited one.
+type Revision struct {
+	// Replaced is when the newer version replaced this one.
+	Replaced time.Time `json:"replaced"`
+	Story    *Story    `json:"story"`
+}
+
+// AddRevision records `old` as the previous version of the story.
+func (s *Story) AddRevision(old *Story, replaced time.Time) {
+	prev := *old
+	prev.Revisions = nil
+
+	s.Revisions = append(append([]Revision(nil), s.Revisions...),
+		Revision{Replaced: replaced.UTC(), Story: &prev})
+}
+
+// Versions returns all the versions of the story, oldest first, along with the
+// times they were replaced. The last one is the story itself, without its
+// revisions, and a zero time.
+func (s *Story) Versions() []Revision {
+	cur := *s
+	cur.Revisions = nil
+
+	return append(append([]Revision(nil), s.Revisions...), Revision{Story: &cur})
+}
+
+func revisionsEqual(a, b []Revision) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Replaced.Equal(b[i].Replaced) || len(a[i].Story.Diff(b[i].Story)) > 0 {
+			return false
+		}
+	}
+	return true
 }
 
 func (s Story) String() string {
@@ -118,22 +881,171 @@ func (s Story) String() string {
 	res += fmt.Sprintf("\ttitle: %s\n", s.Title)
 	res += fmt.Sprintf("\ttranscript: %s\n", s.Transcript)
 	res += fmt.Sprintf("\tyear: %d\n", s.Year)
+	if s.LocalImage != nil {
+		res += fmt.Sprintf("\tlocal_image: %s\n", *s.LocalImage)
+	}
+	if len(s.Revisions) > 0 {
+		res += fmt.Sprintf("\trevisions: %d\n", len(s.Revisions))
+	}
+	if speakers := s.Script.Speakers(); len(speakers) > 0 {
+		res += fmt.Sprintf("\tspeakers: %s\n", strings.Join(speakers, ", "))
+	}
 
 	return res
 }
 
+// Header describes an index: the version of its schema, the version of xkcd
+// that last wrote it and where and when the stories were fetched.
+type Header struct {
+	SchemaVersion int    `json:"schema_version"`
+	ToolVersion   string `json:"tool_version"`
+	XkcdURI       string `json:"xkcd_uri"`
+	// Created is when the index was created or, for indexes older than the
+	// header, upgraded.
+	Created time.Time `json:"created"`
+	// Updated is when the index was last written.
+	Updated time.Time `json:"updated"`
+	// LastSync is when the stories were last fetched successfully.
+	LastSync time.Time `json:"last_sync"`
+}
+
+func (h Header) String() string {
+	return fmt.Sprintf("schema version: %d, tool version: %s, xkcd uri: %s, "+
+		"created: %s, updated: %s, last sync: %s", h.SchemaVersion, h.ToolVersion,
+		h.XkcdURI, formatTime(h.Created), formatTime(h.Updated), formatTime(h.LastSync))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
 type AllStories map[int]*Story
 
+// Nums returns the numbers of all the stories, in ascending order.
+func (a AllStories) Nums() []int {
+	res := make([]int, 0, len(a))
+
+	for k := range a {
+		res = append(res, k)
+	}
+	sort.Ints(res)
+
+	return res
+}
+
 func (a AllStories) String() string {
 	res := ""
 
-	for i := range a {
+	for _, i := range a.Nums() {
 		res += fmt.Sprintf("Story %d:\n%s", i, *a[i])
 	}
 
 	return res
 }
 
+// MaxNum returns the highest story number present, or 0 if there are none.
+func (a AllStories) MaxNum() int {
+	res := 0
+
+	for k := range a {
+		if k > res {
+			res = k
+		}
+	}
+
+	return res
+}
+
+// FieldDiff describes a single field that differs between two stories.
+type FieldDiff struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+func (d FieldDiff) String() string {
+	return fmt.Sprintf("%s: %#v -> %#v", d.Field, d.Old, d.New)
+}
+
+// Diff returns all the fields that differ between the two stories.
+func (s *Story) Diff(o *Story) []FieldDiff {
+	var res []FieldDiff
+
+	a, b := reflect.ValueOf(s).Elem(), reflect.ValueOf(o).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		var equal bool
+		switch a.Type().Field(i).Name {
+		case "Revisions":
+			equal = revisionsEqual(s.Revisions, o.Revisions)
+		case "Script":
+			// It is derived from the transcript, and so changes with it.
+			continue
+		default:
+			equal = reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface())
+		}
+		if !equal {
+			res = append(res, FieldDiff{
+				Field: a.Type().Field(i).Name,
+				Old:   a.Field(i).Interface(),
+				New:   b.Field(i).Interface(),
+			})
+		}
+	}
+
+	return res
+}
+
+// Change describes a story that was added or modified by an update. Diffs is
+// empty for newly added stories.
+type Change struct {
+	Story *Story
+	Diffs []FieldDiff
+}
+
+// IsNew tells whether the story was added rather than modified.
+func (c Change) IsNew() bool {
+	return len(c.Diffs) == 0
+}
+
+func (c Change) String() string {
+	if c.IsNew() {
+		return fmt.Sprintf("new story %d: %s", c.Story.Num, c.Story.Title)
+	}
+
+	fields := make([]string, 0, len(c.Diffs))
+	for _, d := range c.Diffs {
+		fields = append(fields, d.Field)
+	}
+	return fmt.Sprintf("edited story %d: %s (%s)", c.Story.Num, c.Story.Title,
+		strings.Join(fields, ", "))
+}
+
+// Hit is a single search result along with its relevance score.
+type Hit struct {
+	Story *Story
+	Score float64
+}
+
+// Hits holds search results, ordered from the most relevant one.
+type Hits []Hit
+
+func (h Hits) String() string {
+	res := ""
+
+	for _, v := range h {
+		if v.Score != 0 {
+			res += fmt.Sprintf("Story %d (score %.3f):\n%s", v.Story.Num, v.Score, *v.Story)
+		} else {
+			res += fmt.Sprintf("Story %d:\n%s", v.Story.Num, *v.Story)
+		}
+	}
+
+	return res
+}
+
 func (a AllStories) MarshalJSON() ([]byte, error) {
 	res := make(map[string]Story)
 
@@ -157,7 +1069,8 @@ func (a AllStories) UnmarshalJSON(in []byte) error {
 		if i, err = strconv.Atoi(k); err != nil {
 			return err
 		}
-		a[i] = &v
+		story := v
+		a[i] = &story
 	}
 
 	return nil
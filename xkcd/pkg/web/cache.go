@@ -0,0 +1,165 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// cacheEntry is a cached response, along with the validators needed to
+// revalidate it.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Stored       time.Time `json:"stored"`
+	Body         []byte    `json:"body"`
+}
+
+// CacheStats counts how the requests made through a cache were served.
+type CacheStats struct {
+	// Hits are the requests answered with 304 Not Modified, served from the
+	// cache.
+	Hits int64
+	// Misses are the requests that downloaded the whole response, because it
+	// was not cached, or it changed.
+	Misses int64
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses", s.Hits, s.Misses)
+}
+
+// Sub returns the requests counted in s but not in o, o being an earlier
+// snapshot of the same cache.
+func (s CacheStats) Sub(o CacheStats) CacheStats {
+	return CacheStats{Hits: s.Hits - o.Hits, Misses: s.Misses - o.Misses}
+}
+
+// Cache is an on-disk HTTP cache keeping the responses that carry an `ETag`
+// or a `Last-Modified` header. Cached responses are always revalidated with
+// `If-None-Match` and `If-Modified-Since`, so an unchanged response costs a
+// 304 instead of a download. It is safe for concurrent use.
+type Cache struct {
+	dir    string
+	hits   int64
+	misses int64
+}
+
+// NewCache returns a cache keeping its entries in `dir`, which is created
+// when the first entry is stored.
+func NewCache(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// Stats returns the requests counted so far.
+func (c *Cache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+	}
+}
+
+func (c *Cache) location(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// lookup returns the cached response for the url, nil if there is none or it
+// can not be read.
+func (c *Cache) lookup(url string) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+
+	blob, err := ioutil.ReadFile(c.location(url))
+	if err != nil {
+		return nil
+	}
+	e := new(cacheEntry)
+	if err = json.Unmarshal(blob, e); err != nil || e.URL != url {
+		return nil
+	}
+
+	return e
+}
+
+// conditional adds the validators of the cached response to the headers of
+// the request.
+func (e *cacheEntry) conditional() http.Header {
+	h := http.Header{}
+
+	if e == nil {
+		return h
+	}
+	if e.ETag != "" {
+		h.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		h.Set("If-Modified-Since", e.LastModified)
+	}
+
+	return h
+}
+
+// hit records a request answered from the cache.
+func (c *Cache) hit() {
+	if c != nil {
+		atomic.AddInt64(&c.hits, 1)
+	}
+}
+
+// store records a downloaded response, keeping it if it can be revalidated.
+// The entry is replaced atomically, so that concurrent readers never see a
+// partial one.
+func (c *Cache) store(url string, h http.Header, body []byte) error {
+	if c == nil {
+		return nil
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	e := cacheEntry{
+		URL:          url,
+		ETag:         h.Get("ETag"),
+		LastModified: h.Get("Last-Modified"),
+		Stored:       time.Now().UTC(),
+		Body:         body,
+	}
+	if e.ETag == "" && e.LastModified == "" {
+		return nil
+	}
+
+	blob, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.dir, ".entry")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(blob)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.location(url))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
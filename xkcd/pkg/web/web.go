@@ -1,19 +1,120 @@
+// Package web fetches stories and images from xkcd.
 package web
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/vespian/go-exercises/xkcd/pkg/types"
 )
 
-func fetchStory(url string) (*types.Story, error) {
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+// retryableError marks failures that are likely to go away if the request is
+// repeated: network errors, 5xx and 429 responses. `after` holds the delay
+// requested by the server via `Retry-After`, if any.
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func parseRetryAfter(h string) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// backoff returns the delay before the given retry attempt: an exponentially
+// growing, capped delay with full jitter.
+func backoff(attempt int) time.Duration {
+	d := retryMaxDelay
+	if attempt < 16 {
+		if exp := retryBaseDelay << uint(attempt); exp < d {
+			d = exp
+		}
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// Client fetches stories and images. The zero value is ready to use, it
+// makes the requests with http.DefaultClient, caches nothing and discards the
+// progress messages.
+type Client struct {
+	// HTTP makes the requests, http.DefaultClient if nil.
+	HTTP *http.Client
+	// Cache keeps the fetched stories so that unchanged ones are not
+	// downloaded again, nothing is cached if nil.
+	Cache *Cache
+	// Logger receives the progress messages.
+	Logger types.Logger
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTP == nil {
+		return http.DefaultClient
+	}
+	return c.HTTP
+}
+
+// get issues a GET request with the given headers that is aborted when the
+// context is cancelled. Failures other than cancellation are retryable.
+func (c *Client) get(ctx context.Context, url string, h http.Header,
+) (
+	*http.Response,
+	error,
+) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+
+	resp, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, retryableError{err: err}
+	}
+
+	return resp, nil
+}
+
+// fetchStory returns nil if the story does not exist. Cached stories are
+// revalidated instead of downloaded.
+func (c *Client) fetchStory(ctx context.Context, url string) (*types.Story, error) {
 	var result types.Story
 
-	fmt.Printf("Fetching url %s\n", url)
+	c.Logger.Printf(types.LogProgress, "Fetching url %s", url)
 
-	resp, err := http.Get(url)
+	cached := c.Cache.lookup(url)
+	resp, err := c.get(ctx, url, cached.conditional())
 	if err != nil {
 		return nil, err
 	}
@@ -26,33 +127,335 @@ func fetchStory(url string) (*types.Story, error) {
 		return nil, nil
 	}
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= http.StatusInternalServerError {
+		return nil, retryableError{
+			err:   fmt.Errorf("fetching story %s failed: %s", url, resp.Status),
+			after: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	var blob []byte
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		c.Cache.hit()
+		blob = cached.Body
+	case resp.StatusCode == http.StatusOK:
+		if blob, err = ioutil.ReadAll(resp.Body); err != nil {
+			return nil, retryableError{err: err}
+		}
+		if err = c.Cache.store(url, resp.Header, blob); err != nil {
+			c.Logger.Printf(types.LogNotice, "Caching %s failed: %s", url, err)
+		}
+	default:
 		return nil, fmt.Errorf("fetching story %s failed: %s", url, resp.Status)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := json.Unmarshal(blob, &result); err != nil {
 		return nil, fmt.Errorf("decoding story %s failed: %s", url, err)
 	}
 
-	return result, nil
+	return &result, nil
 }
 
-func Fetch(format string, rg types.Range) (types.AllStories, error) {
-	res := make(types.AllStories)
+// withRetries calls fn, repeating it up to `retries` times as long as it fails
+// with a retryableError. Waiting between the attempts is aborted when the
+// context is cancelled.
+func (c *Client) withRetries(ctx context.Context, url string, retries int,
+	fn func() error,
+) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
 
-	for i := rg.Min; i < rg.Max; i++ {
-		url := fmt.Sprintf(format, i)
-		story, err := fetchStory(url)
-		if err != nil {
-			return nil, fmt.Errorf("Fetch failed: %s", err)
+		rerr, ok := err.(retryableError)
+		if !ok || attempt >= retries {
+			return err
 		}
-		if story == nil {
-			fmt.Printf("Reached end of stories at id %d\n", i)
+
+		delay := rerr.after
+		if delay == 0 {
+			delay = backoff(attempt)
+		}
+		c.Logger.Printf(types.LogNotice, "Fetching %s failed: %s, retrying in %s",
+			url, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Client) fetchStoryWithRetries(ctx context.Context, url string, retries int,
+) (
+	*types.Story,
+	error,
+) {
+	var res *types.Story
+
+	err := c.withRetries(ctx, url, retries, func() error {
+		var err error
+		res, err = c.fetchStory(ctx, url)
+		return err
+	})
+
+	return res, err
+}
+
+// FetchStory downloads the story with the given number, `format` being the
+// address of the stories with a %d in place of the number. Failed requests
+// are retried up to `retries` times. ErrNotFound is returned if the story
+// does not exist.
+func (c *Client) FetchStory(ctx context.Context, format string, num int, retries int,
+) (
+	*types.Story,
+	error,
+) {
+	res, err := c.fetchStoryWithRetries(ctx, fmt.Sprintf(format, num), retries)
+	if err == nil && res == nil {
+		err = fmt.Errorf("story %d: %w", num, types.ErrNotFound)
+	}
+
+	return res, err
+}
+
+func (c *Client) fetchBlob(ctx context.Context, url string) ([]byte, error) {
+	c.Logger.Printf(types.LogProgress, "Fetching url %s", url)
+
+	resp, err := c.get(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= http.StatusInternalServerError {
+		return nil, retryableError{
+			err:   fmt.Errorf("fetching %s failed: %s", url, resp.Status),
+			after: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("fetching %s failed: %w", url, types.ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s failed: %s", url, resp.Status)
+	}
+
+	blob, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, retryableError{err: err}
+	}
+
+	return blob, nil
+}
+
+// FetchBlob downloads the given url, retrying failed requests up to `retries`
+// times.
+func (c *Client) FetchBlob(ctx context.Context, url string, retries int) ([]byte, error) {
+	var res []byte
+
+	err := c.withRetries(ctx, url, retries, func() error {
+		var err error
+		res, err = c.fetchBlob(ctx, url)
+		return err
+	})
+
+	return res, err
+}
+
+// fetcher keeps the state shared between the workers of a single Fetch call.
+type fetcher struct {
+	sync.Mutex
+
+	c          *Client
+	ctx        context.Context
+	format     string
+	retries    int
+	every      int
+	checkpoint func(types.AllStories) error
+	res        types.AllStories
+	misses     []int
+	highest    int
+	firstErr   error
+	inflight   sync.WaitGroup
+}
+
+func (f *fetcher) worker(ids <-chan int, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for i := range ids {
+		story, err := f.c.fetchStoryWithRetries(f.ctx, fmt.Sprintf(f.format, i), f.retries)
+
+		f.Lock()
+		switch {
+		case err != nil && err == f.ctx.Err():
+			// Cancellation is reported by Fetch itself.
+		case err != nil:
+			if f.firstErr == nil {
+				f.firstErr = fmt.Errorf("Fetch failed: %w", err)
+			}
+		case story == nil:
+			f.misses = append(f.misses, i)
+		default:
+			f.c.Logger.Printf(types.LogProgress, "Fetched story %d", i)
+			f.res[i] = story
+			if i > f.highest {
+				f.highest = i
+			}
+			if f.every > 0 && len(f.res)%f.every == 0 && f.firstErr == nil {
+				if err := f.checkpoint(f.res); err != nil {
+					f.firstErr = fmt.Errorf("checkpointing failed: %w", err)
+				}
+			}
+		}
+		f.Unlock()
+
+		f.inflight.Done()
+	}
+}
+
+// lowestMiss returns the lowest missing story above all the stories seen so
+// far, or 0 if there is none. Must be called with the lock held.
+func (f *fetcher) lowestMiss() int {
+	res := 0
+
+	for _, m := range f.misses {
+		if m > f.highest && (res == 0 || m < res) {
+			res = m
+		}
+	}
+
+	return res
+}
+
+// Fetch downloads all the stories from the given range whose numbers are not
+// in `known`, using `params.Workers` concurrent requests and at most
+// `params.Rate` requests per second (no limit if zero). Failed requests are
+// retried up to `params.Retries` times.
+//
+// Every `params.CheckpointEvery` fetched stories, `checkpoint` is called with
+// all the stories fetched so far. It must not retain the map it was given.
+// On error, the stories fetched before the failure are returned along with it.
+//
+// A missing story only marks the end of the archive if no story past it turns
+// up once all the requests that were already in flight are done, so gaps like
+// story 404 are skipped.
+//
+// Cancelling the context stops fetching, the stories fetched until then are
+// returned along with the context's error.
+func (c *Client) Fetch(ctx context.Context, format string, rg types.Range, known map[int]bool,
+	params types.FetchParams, checkpoint func(types.AllStories) error,
+) (
+	types.AllStories,
+	error,
+) {
+	var wg sync.WaitGroup
+	var limiter <-chan time.Time
+
+	f := &fetcher{
+		c:          c,
+		ctx:        ctx,
+		format:     format,
+		retries:    params.Retries,
+		every:      params.CheckpointEvery,
+		checkpoint: checkpoint,
+		res:        make(types.AllStories),
+	}
+	for k := range known {
+		if k > f.highest {
+			f.highest = k
+		}
+	}
+	if checkpoint == nil {
+		f.every = 0
+	}
+
+	workers := params.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	// Rates too high for the resolution of the ticker are as good as no
+	// limit at all, too low ones are clamped to the longest interval.
+	if d := float64(time.Second) / params.Rate; params.Rate > 0 && d >= 1 {
+		interval := time.Duration(math.MaxInt64)
+		if d < float64(interval) {
+			interval = time.Duration(d)
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		limiter = t.C
+	}
+
+	ids := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go f.worker(ids, &wg)
+	}
+
+	// The range is inclusive, stopping at the wrap around keeps a Max of
+	// math.MaxInt from looping forever.
+	for i := rg.Min; i <= rg.Max && i >= rg.Min; i++ {
+		if known[i] {
+			continue
+		}
+
+		f.Lock()
+		failed, miss := f.firstErr != nil, f.lowestMiss()
+		f.Unlock()
+		if failed {
+			break
+		}
+		if miss != 0 && i > miss {
+			// Let the workers that are already past the miss finish, and
+			// check whether any of them found a story.
+			f.inflight.Wait()
+			f.Lock()
+			failed, miss = f.firstErr != nil, f.lowestMiss()
+			f.Unlock()
+			if failed {
+				break
+			}
+			if miss != 0 {
+				c.Logger.Printf(types.LogProgress, "Reached end of stories at id %d", miss)
+				break
+			}
+		}
+
+		if limiter != nil {
+			select {
+			case <-limiter:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
 			break
 		}
-		fmt.Printf("Fetched story %d\n", i)
-		res[i] = story
+		f.inflight.Add(1)
+		select {
+		case ids <- i:
+		case <-ctx.Done():
+			f.inflight.Done()
+		}
+	}
+	close(ids)
+	wg.Wait()
+
+	if f.firstErr != nil {
+		return f.res, f.firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return f.res, err
+	}
+	for _, m := range f.misses {
+		if m < f.highest {
+			c.Logger.Printf(types.LogNotice, "Story %d does not exist, skipped", m)
+		}
 	}
 
-	return res, nil
+	return f.res, nil
 }
@@ -0,0 +1,270 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/vespian/go-exercises/xkcd/pkg/types"
+)
+
+// archive serves the given stories at /{num}, answering 404 for the other
+// numbers, and counts the requests for every number.
+type archive struct {
+	sync.Mutex
+	stories  map[int]bool
+	requests map[int]int
+	// fail, if set, handles the request instead when it returns true.
+	fail func(w http.ResponseWriter, r *http.Request, num int) bool
+}
+
+func newArchive(t *testing.T, nums ...int) (*archive, *httptest.Server) {
+	t.Helper()
+
+	a := &archive{stories: map[int]bool{}, requests: map[int]int{}}
+	for _, n := range nums {
+		a.stories[n] = true
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var num int
+		if _, err := fmt.Sscanf(r.URL.Path, "/%d", &num); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		a.Lock()
+		a.requests[num]++
+		fail, ok := a.fail, a.stories[num]
+		a.Unlock()
+
+		if fail != nil && fail(w, r, num) {
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"num": %d, "title": "Story %d", "year": "2006", "month": "1", "day": "1"}`,
+			num, num)
+	}))
+	t.Cleanup(ts.Close)
+
+	return a, ts
+}
+
+func nums(a types.AllStories) []int {
+	res := a.Nums()
+	if res == nil {
+		res = []int{}
+	}
+	return res
+}
+
+func seq(from, to int) []int {
+	res := []int{}
+	for i := from; i <= to; i++ {
+		res = append(res, i)
+	}
+	return res
+}
+
+func TestFetch(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		stories []int
+		rg      types.Range
+		known   []int
+		workers int
+		want    []int
+	}{
+		{"all", seq(1, 5), types.FullRange, nil, 1, seq(1, 5)},
+		{"all concurrently", seq(1, 20), types.FullRange, nil, 4, seq(1, 20)},
+		{"empty archive", nil, types.FullRange, nil, 1, []int{}},
+		{"range", seq(1, 10), types.Range{Min: 3, Max: 6}, nil, 2, seq(3, 6)},
+		{"single story range", seq(1, 10), types.Range{Min: 10, Max: 10}, nil, 1, []int{10}},
+		{"known stories", seq(1, 6), types.FullRange, []int{1, 2, 4}, 1, []int{3, 5, 6}},
+		{"all known", seq(1, 3), types.Range{Min: 1, Max: 3}, seq(1, 3), 1, []int{}},
+		{"gap", []int{1, 2, 3, 5, 6}, types.FullRange, nil, 1, []int{1, 2, 3, 5, 6}},
+		{"gap concurrently", []int{1, 2, 3, 5, 6, 7, 8}, types.FullRange, nil, 3,
+			[]int{1, 2, 3, 5, 6, 7, 8}},
+		{"gap after known stories", []int{1, 2, 3, 5, 6}, types.FullRange, seq(1, 3), 1,
+			[]int{5, 6}},
+		{"missing first story", seq(2, 4), types.FullRange, nil, 1, seq(2, 4)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ts := newArchive(t, tc.stories...)
+
+			known := map[int]bool{}
+			for _, k := range tc.known {
+				known[k] = true
+			}
+			c := &Client{}
+			res, err := c.Fetch(context.Background(), ts.URL+"/%d", tc.rg, known,
+				types.FetchParams{Workers: tc.workers}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := nums(res); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("fetched %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFetchSkipsKnown(t *testing.T) {
+	a, ts := newArchive(t, seq(1, 5)...)
+
+	c := &Client{}
+	_, err := c.Fetch(context.Background(), ts.URL+"/%d", types.FullRange,
+		map[int]bool{2: true, 3: true}, types.FetchParams{Workers: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{2, 3} {
+		if a.requests[n] != 0 {
+			t.Errorf("known story %d was requested %d times", n, a.requests[n])
+		}
+	}
+	for _, n := range []int{1, 4, 5, 6} {
+		if a.requests[n] != 1 {
+			t.Errorf("story %d was requested %d times, want once", n, a.requests[n])
+		}
+	}
+}
+
+func TestFetchCheckpoint(t *testing.T) {
+	_, ts := newArchive(t, seq(1, 7)...)
+
+	var sizes []int
+	checkpoint := func(a types.AllStories) error {
+		sizes = append(sizes, len(a))
+		return nil
+	}
+	c := &Client{}
+	res, err := c.Fetch(context.Background(), ts.URL+"/%d", types.FullRange, nil,
+		types.FetchParams{Workers: 1, CheckpointEvery: 3}, checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 7 {
+		t.Errorf("fetched %d stories, want 7", len(res))
+	}
+	if want := []int{3, 6}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("checkpointed %v stories, want %v", sizes, want)
+	}
+
+	// A failing checkpoint stops fetching.
+	failed := errors.New("disk full")
+	_, err = c.Fetch(context.Background(), ts.URL+"/%d", types.FullRange, nil,
+		types.FetchParams{Workers: 1, CheckpointEvery: 3},
+		func(types.AllStories) error { return failed })
+	if !errors.Is(err, failed) {
+		t.Errorf("got error %v, want %v", err, failed)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	a, ts := newArchive(t, seq(1, 5)...)
+	a.fail = func(w http.ResponseWriter, r *http.Request, num int) bool {
+		if num != 3 {
+			return false
+		}
+		http.Error(w, "teapot", http.StatusTeapot)
+		return true
+	}
+
+	c := &Client{}
+	res, err := c.Fetch(context.Background(), ts.URL+"/%d", types.FullRange, nil,
+		types.FetchParams{Workers: 1}, nil)
+	if err == nil {
+		t.Fatal("fetching succeeded despite a failing story")
+	}
+	if res[1] == nil || res[2] == nil || res[3] != nil {
+		t.Errorf("fetched %v before the failure, want 1 and 2 without 3", nums(res))
+	}
+	// The request for story 4 may be in flight already when story 3 fails,
+	// nothing is requested after that.
+	if a.requests[5] != 0 {
+		t.Errorf("story 5 was requested after the failure")
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	a, ts := newArchive(t, seq(1, 3)...)
+	a.fail = func(w http.ResponseWriter, r *http.Request, num int) bool {
+		// The first request for story 2 fails.
+		if num != 2 || a.requests[2] > 1 {
+			return false
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return true
+	}
+
+	c := &Client{}
+	res, err := c.Fetch(context.Background(), ts.URL+"/%d", types.FullRange, nil,
+		types.FetchParams{Workers: 1, Retries: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nums(res); !reflect.DeepEqual(got, seq(1, 3)) {
+		t.Errorf("fetched %v, want [1 2 3]", got)
+	}
+	if a.requests[2] != 2 {
+		t.Errorf("story 2 was requested %d times, want 2", a.requests[2])
+	}
+}
+
+func TestFetchCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, ts := newArchive(t, seq(1, 10)...)
+	a.fail = func(w http.ResponseWriter, r *http.Request, num int) bool {
+		if num < 3 {
+			return false
+		}
+		cancel()
+		<-r.Context().Done()
+		return true
+	}
+
+	c := &Client{}
+	res, err := c.Fetch(ctx, ts.URL+"/%d", types.FullRange, nil,
+		types.FetchParams{Workers: 1}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if got := nums(res); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("fetched %v before cancellation, want [1 2]", got)
+	}
+}
+
+func TestLowestMiss(t *testing.T) {
+	for _, tc := range []struct {
+		misses  []int
+		highest int
+		want    int
+	}{
+		{nil, 0, 0},
+		{nil, 10, 0},
+		{[]int{5}, 3, 5},
+		{[]int{5}, 5, 0},
+		{[]int{5}, 7, 0},
+		{[]int{9, 5, 7}, 3, 5},
+		{[]int{9, 5, 7}, 6, 7},
+		{[]int{4, 12, 11}, 10, 11},
+	} {
+		f := &fetcher{misses: tc.misses, highest: tc.highest}
+		if got := f.lowestMiss(); got != tc.want {
+			t.Errorf("lowestMiss() of misses %v above %d = %d, want %d", tc.misses,
+				tc.highest, got, tc.want)
+		}
+	}
+}